@@ -2,29 +2,29 @@ package api
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"fmt"
 	rq "github.com/carlmjohnson/requests"
 	sts "github.com/ravan/stackstate-client/stackstate"
-	"log/slog"
+	"github.com/ravan/stackstate-client/stackstate/promql"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	url       string
-	conf      *sts.StackState
-	legacyApi bool
+	url           string
+	conf          *sts.StackState
+	apiMode       sts.ApiMode
+	httpClient    *http.Client
+	mu            sync.Mutex
+	serverInfo    *ServerInfo
+	versionFailed time.Time // last failed version detection
 }
 
 var (
-	transport = &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
 	DumpHttpRequest bool
 )
 
@@ -33,16 +33,24 @@ const (
 	DefaultTimeout        = "10s"
 )
 
-func NewClient(conf *sts.StackState) *Client {
+func NewClient(conf *sts.StackState, opts ...sts.Option) *Client {
 	url, _ := strings.CutSuffix(conf.ApiUrl, "/")
-	return &Client{url: url, conf: conf, legacyApi: conf.LegacyApi}
+	o := conf.ClientOptions(opts...)
+	httpClient := *o.NewHTTPClient()
+	httpClient.Transport = sts.DecompressTransport(httpClient.Transport)
+	return &Client{url: url, conf: conf, apiMode: o.ApiMode, httpClient: &httpClient}
 }
 
 func (c *Client) Status() (*ServerInfo, error) {
+	return c.StatusCtx(context.Background())
+}
+
+// StatusCtx is Status with a caller supplied context.
+func (c *Client) StatusCtx(ctx context.Context) (*ServerInfo, error) {
 	var s ServerInfo
 	err := c.apiRequests("server/info").
 		ToJSON(&s).
-		Fetch(context.Background())
+		Fetch(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -50,10 +58,15 @@ func (c *Client) Status() (*ServerInfo, error) {
 }
 
 func (c *Client) GetTrace(id string) (*Trace, error) {
+	return c.GetTraceCtx(context.Background(), id)
+}
+
+// GetTraceCtx is GetTrace with a caller supplied context.
+func (c *Client) GetTraceCtx(ctx context.Context, id string) (*Trace, error) {
 	var res Trace
 	err := c.apiRequests(fmt.Sprintf("traces/%s", id)).
 		ToJSON(&res).
-		Fetch(context.TODO())
+		Fetch(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -61,10 +74,15 @@ func (c *Client) GetTrace(id string) (*Trace, error) {
 }
 
 func (c *Client) GetTraceSpan(traceId string, spanId string) (*Span, error) {
+	return c.GetTraceSpanCtx(context.Background(), traceId, spanId)
+}
+
+// GetTraceSpanCtx is GetTraceSpan with a caller supplied context.
+func (c *Client) GetTraceSpanCtx(ctx context.Context, traceId string, spanId string) (*Span, error) {
 	var res Span
 	err := c.apiRequests(fmt.Sprintf("traces/%s/spans/%s", traceId, spanId)).
 		ToJSON(&res).
-		Fetch(context.TODO())
+		Fetch(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -72,8 +90,13 @@ func (c *Client) GetTraceSpan(traceId string, spanId string) (*Span, error) {
 }
 
 func (c *Client) QueryTraces(req *TraceQueryRequest) (*TraceQueryResponse, error) {
-	if c.legacyApi {
-		return c.legacyQuerySpans(req)
+	return c.QueryTracesCtx(context.Background(), req)
+}
+
+// QueryTracesCtx is QueryTraces with a caller supplied context.
+func (c *Client) QueryTracesCtx(ctx context.Context, req *TraceQueryRequest) (*TraceQueryResponse, error) {
+	if c.useLegacyApi(ctx) {
+		return c.legacyQuerySpans(ctx, req)
 	}
 
 	var res TraceQueryResponse
@@ -85,7 +108,7 @@ func (c *Client) QueryTraces(req *TraceQueryRequest) (*TraceQueryResponse, error
 		Param("pageSize", strconv.Itoa(req.PageSize)).
 		BodyJSON(req.TraceQuery).
 		ToJSON(&res).
-		Fetch(context.TODO())
+		Fetch(sts.Idempotent(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -96,9 +119,11 @@ func toMs(t time.Time) string {
 	return strconv.FormatInt(t.UnixMilli(), 10)
 }
 
-func (c *Client) legacyQuerySpans(req *TraceQueryRequest) (*TraceQueryResponse, error) {
-	req.TraceQuery.Filter = req.TraceQuery.SpanFilter
-	req.TraceQuery.SpanFilter = SpanFilter{}
+func (c *Client) legacyQuerySpans(ctx context.Context, req *TraceQueryRequest) (*TraceQueryResponse, error) {
+	// Work on a copy so the request can be reused, e.g. to fetch the next page.
+	query := req.TraceQuery
+	query.Filter = query.SpanFilter
+	query.SpanFilter = SpanFilter{}
 	var res SpansQueryResponse
 	err := c.apiRequests("traces/spans").
 		Post().
@@ -106,9 +131,9 @@ func (c *Client) legacyQuerySpans(req *TraceQueryRequest) (*TraceQueryResponse,
 		Param("start", toMs(req.Start)).
 		Param("page", strconv.Itoa(req.Page)).
 		Param("pageSize", strconv.Itoa(req.PageSize)).
-		BodyJSON(req.TraceQuery).
+		BodyJSON(query).
 		ToJSON(&res).
-		Fetch(context.TODO())
+		Fetch(sts.Idempotent(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -126,13 +151,18 @@ func (c *Client) legacyQuerySpans(req *TraceQueryRequest) (*TraceQueryResponse,
 // Query is the promql query and Time the single point.
 // Timeout is in the form "<number><unit (y|w|d|h|m|s|ms)>". Example 10ms.
 func (c *Client) QueryMetric(query string, at time.Time, timeout string) (*MetricQueryResponse, error) {
+	return c.QueryMetricCtx(context.Background(), query, at, timeout)
+}
+
+// QueryMetricCtx is QueryMetric with a caller supplied context.
+func (c *Client) QueryMetricCtx(ctx context.Context, query string, at time.Time, timeout string) (*MetricQueryResponse, error) {
 	var m MetricQueryResponse
 	err := c.apiRequests("metrics/query").
 		Param("query", query).
 		Param("timeout", timeout).
 		Param("time", toMs(at)).
 		ToJSON(&m).
-		Fetch(context.TODO())
+		Fetch(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -145,6 +175,11 @@ func (c *Client) QueryMetric(query string, at time.Time, timeout string) (*Metri
 // Step is the promstep in the same format as Timeout.
 // Timeout is in the form "<number><unit (y|w|d|h|m|s|ms)>". Example 10ms.
 func (c *Client) QueryRangeMetric(query string, start time.Time, end time.Time, step, timeout string) (*MetricQueryResponse, error) {
+	return c.QueryRangeMetricCtx(context.Background(), query, start, end, step, timeout)
+}
+
+// QueryRangeMetricCtx is QueryRangeMetric with a caller supplied context.
+func (c *Client) QueryRangeMetricCtx(ctx context.Context, query string, start time.Time, end time.Time, step, timeout string) (*MetricQueryResponse, error) {
 	var m MetricQueryResponse
 	err := c.apiRequests("metrics/query_range").
 		Param("query", query).
@@ -153,37 +188,58 @@ func (c *Client) QueryRangeMetric(query string, start time.Time, end time.Time,
 		Param("start", toMs(start)).
 		Param("end", toMs(end)).
 		ToJSON(&m).
-		Fetch(context.TODO())
+		Fetch(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return &m, nil
 }
 
-func (c *Client) SnapShotTopologyQuery(query string) ([]ViewComponent, error) {
-	req := NewViewSnapshotRequest(query)
-	res, err := c.ViewSnapshot(req)
+// QueryMetricExpr is QueryMetricCtx for a query built with the promql package.
+// The timeout is converted to the Prometheus duration format.
+func (c *Client) QueryMetricExpr(ctx context.Context, query promql.Expr, at time.Time, timeout time.Duration) (*MetricQueryResponse, error) {
+	return c.QueryMetricCtx(ctx, query.String(), at, promql.Duration(timeout))
+}
+
+// QueryRangeMetricExpr is QueryRangeMetricCtx for a query built with the promql package.
+// Step and timeout are converted to the Prometheus duration format.
+func (c *Client) QueryRangeMetricExpr(ctx context.Context, query promql.Expr, start time.Time, end time.Time, step, timeout time.Duration) (*MetricQueryResponse, error) {
+	return c.QueryRangeMetricCtx(ctx, query.String(), start, end, promql.Duration(step), promql.Duration(timeout))
+}
+
+func (c *Client) SnapShotTopologyQuery(query string, opts ...SnapshotOption) ([]ViewComponent, error) {
+	return c.SnapShotTopologyQueryCtx(context.Background(), query, opts...)
+}
+
+// SnapShotTopologyQueryCtx is SnapShotTopologyQuery with a caller supplied context.
+func (c *Client) SnapShotTopologyQueryCtx(ctx context.Context, query string, opts ...SnapshotOption) ([]ViewComponent, error) {
+	req := NewViewSnapshotRequest(query, opts...)
+	res, err := c.ViewSnapshotCtx(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 	if !res.Success {
-		return nil, errors.New(res.Errors[0].Message)
+		return nil, res.Err()
 	}
 	return res.Components, nil
 }
 
 func (c *Client) ViewSnapshot(req *ViewSnapshotRequest) (*ViewSnapshotResponse, error) {
+	return c.ViewSnapshotCtx(context.Background(), req)
+}
+
+// ViewSnapshotCtx is ViewSnapshot with a caller supplied context.
+func (c *Client) ViewSnapshotCtx(ctx context.Context, req *ViewSnapshotRequest) (*ViewSnapshotResponse, error) {
 	var res querySnapshotResult
-	var e ErrorResp
 	err := c.apiRequests("snapshot").
 		Post().
 		BodyJSON(&req).
-		ErrorJSON(&e).
 		ToJSON(&res).
-		Fetch(context.TODO())
+		Fetch(sts.Idempotent(ctx))
 	if err != nil {
-		if e.Errors != nil && len(e.Errors) > 0 {
-			return &ViewSnapshotResponse{Success: false, Errors: e.Errors}, nil
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && len(apiErr.Errors) > 0 {
+			return &ViewSnapshotResponse{Success: false, Errors: apiErr.Errors, err: apiErr}, nil
 		}
 		return nil, err
 	}
@@ -192,26 +248,46 @@ func (c *Client) ViewSnapshot(req *ViewSnapshotRequest) (*ViewSnapshotResponse,
 }
 
 func (c *Client) Layers() (*map[int64]NodeType, error) {
-	return c.getNodesOfType("Layer")
+	return c.LayersCtx(context.Background())
+}
+
+// LayersCtx is Layers with a caller supplied context.
+func (c *Client) LayersCtx(ctx context.Context) (*map[int64]NodeType, error) {
+	return c.getNodesOfType(ctx, "Layer")
 }
 
 func (c *Client) ComponentTypes() (*map[int64]NodeType, error) {
-	return c.getNodesOfType("ComponentType")
+	return c.ComponentTypesCtx(context.Background())
+}
+
+// ComponentTypesCtx is ComponentTypes with a caller supplied context.
+func (c *Client) ComponentTypesCtx(ctx context.Context) (*map[int64]NodeType, error) {
+	return c.getNodesOfType(ctx, "ComponentType")
 }
 
 func (c *Client) RelationTypes() (*map[int64]NodeType, error) {
-	return c.getNodesOfType("RelationType")
+	return c.RelationTypesCtx(context.Background())
+}
+
+// RelationTypesCtx is RelationTypes with a caller supplied context.
+func (c *Client) RelationTypesCtx(ctx context.Context) (*map[int64]NodeType, error) {
+	return c.getNodesOfType(ctx, "RelationType")
 }
 
 func (c *Client) Domains() (*map[int64]NodeType, error) {
-	return c.getNodesOfType("Domain")
+	return c.DomainsCtx(context.Background())
 }
 
-func (c *Client) getNodesOfType(t string) (*map[int64]NodeType, error) {
+// DomainsCtx is Domains with a caller supplied context.
+func (c *Client) DomainsCtx(ctx context.Context) (*map[int64]NodeType, error) {
+	return c.getNodesOfType(ctx, "Domain")
+}
+
+func (c *Client) getNodesOfType(ctx context.Context, t string) (*map[int64]NodeType, error) {
 	var res []NodeType
 	err := c.apiRequests(fmt.Sprintf("node/%s", t)).
 		ToJSON(&res).
-		Fetch(context.Background())
+		Fetch(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -223,74 +299,63 @@ func (c *Client) getNodesOfType(t string) (*map[int64]NodeType, error) {
 }
 
 func (c *Client) TopologyQuery(query string, at string, fullLoad bool) (*TopoQueryResponse, error) {
-	query, at = sanitizeQuery(query, at)
-	method := "components"
+	return c.TopologyQueryCtx(context.Background(), query, at, fullLoad)
+}
+
+// TopologyQueryCtx is TopologyQuery with a caller supplied context.
+func (c *Client) TopologyQueryCtx(ctx context.Context, query string, at string, fullLoad bool) (*TopoQueryResponse, error) {
+	script := TopologyScript(query).At(at)
 	if fullLoad {
-		method = "fullComponents"
+		script = script.FullComponents()
+	} else {
+		script = script.Components()
 	}
-	body := fmt.Sprintf(`Topology.query('%s')%s.%s()`, query, at, method)
-	return c.executeTopoScript(scriptRequest{
-		ReqType: GroovyScript,
-		Body:    body,
-	})
+	return c.executeScript(ctx, script)
 }
 
 func (c *Client) TopologyStreamQuery(query string, at string, withSyncData bool) (*TopoQueryResponse, error) {
-	query, at = sanitizeQuery(query, at)
-	method := ""
-	if withSyncData {
-		method = ".withSynchronizationData()"
-	}
-	body := fmt.Sprintf(`TopologyStream.query('%s')%s%s`, query, at, method)
-	return c.executeTopoScript(scriptRequest{
-		ReqType: GroovyScript,
-		Body:    body,
-	})
+	return c.TopologyStreamQueryCtx(context.Background(), query, at, withSyncData)
 }
 
-func sanitizeQuery(query string, at string) (string, string) {
-	query = strings.ReplaceAll(query, "'", "\"")
-	if at != "" {
-		at = fmt.Sprintf(".at('%s')", at)
+// TopologyStreamQueryCtx is TopologyStreamQuery with a caller supplied context.
+func (c *Client) TopologyStreamQueryCtx(ctx context.Context, query string, at string, withSyncData bool) (*TopoQueryResponse, error) {
+	script := TopologyStreamScript(query).At(at)
+	if withSyncData {
+		script = script.WithSynchronizationData()
 	}
-	return query, at
+	return c.executeScript(ctx, script)
 }
 
-func (c *Client) executeTopoScript(req scriptRequest) (*TopoQueryResponse, error) {
-	var r SuccessResp
-	var e ErrorResp
-	b, err := json.Marshal(req)
+func (c *Client) executeScript(ctx context.Context, script Script) (*TopoQueryResponse, error) {
+	body, err := script.Build()
 	if err != nil {
 		return nil, err
 	}
-	slog.Debug("request", "body", string(b))
-	err = c.apiRequests("script").
-		BodyJSON(&req).
-		ErrorJSON(&e).
-		ToJSON(&r).
-		Fetch(context.TODO())
+	// Topology scripts only read data, so they are safe to retry.
+	res, err := ExecuteScript[[]SyncComponent](ctx, c, body, ScriptIdempotent())
 	if err != nil {
-		if e.Errors != nil {
-			return &TopoQueryResponse{Success: false, Errors: e.Errors, Data: nil}, nil
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Errors != nil {
+			return &TopoQueryResponse{Success: false, Errors: apiErr.Errors, Data: nil, err: apiErr}, nil
 		}
 		return nil, err
 	}
-	return &TopoQueryResponse{Success: true, Errors: nil, Data: r.Result}, nil
+	return &TopoQueryResponse{Success: true, Errors: nil, Data: res}, nil
 }
 
 func (c *Client) apiRequests(endpoint string) *rq.Builder {
 	uri := fmt.Sprintf("%s/api/%s", c.url, endpoint)
-	return request(uri).
-		Header("X-API-Token", c.conf.ApiToken)
+	return c.request(uri).
+		Header("X-API-Token", c.conf.ApiToken).
+		AddValidator(checkStatus(endpoint))
 }
 
-func request(uri string) *rq.Builder {
+func (c *Client) request(uri string) *rq.Builder {
 	b := rq.URL(uri).
-		ContentType("application/json")
+		ContentType("application/json").
+		Client(c.httpClient)
 	if DumpHttpRequest {
-		b.Transport(rq.Record(nil, "http_dump"))
-	} else {
-		b.Transport(transport)
+		b.Transport(rq.Record(c.httpClient.Transport, "http_dump"))
 	}
 	return b
 }
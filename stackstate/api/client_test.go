@@ -1,10 +1,14 @@
 package api
 
 import (
+	"compress/zlib"
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"github.com/joho/godotenv"
 	sts "github.com/ravan/stackstate-client/stackstate"
+	"github.com/ravan/stackstate-client/stackstate/promql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"log/slog"
@@ -50,8 +54,30 @@ func getClient(t *testing.T, hf http.HandlerFunc) (*Client, *httptest.Server) {
 	return client, server
 }
 
+func TestCompressedResponse(t *testing.T) {
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept-Encoding"), "deflate")
+		file, err := os.ReadFile("../../testdata/api/server/info.json")
+		assert.NoError(t, err)
+		w.Header().Set("Content-Encoding", "deflate")
+		zw := zlib.NewWriter(w)
+		_, _ = zw.Write(file)
+		_ = zw.Close()
+	})
+	defer server.Close()
+
+	info, err := client.Status()
+	require.NoError(t, err)
+	assert.Equal(t, Version{Major: 6, Patch: 3, Commit: "0a6a4bd1d2"}, info.Version)
+	assert.Equal(t, "SaaS", info.DeploymentMode)
+}
+
 func TestTraceQuery(t *testing.T) {
 	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/server/info" {
+			loadRespFile(w, "api/server/info.json")
+			return
+		}
 		assert.Equal(t, "/api/traces/query", r.URL.Path)
 		assert.NotEmpty(t, r.URL.Query().Get("start"))
 		assert.NotEmpty(t, r.URL.Query().Get("end"))
@@ -114,6 +140,34 @@ func TestViewSnapshot(t *testing.T) {
 	assert.Equal(t, 3, len(response))
 }
 
+func TestViewSnapshotRelations(t *testing.T) {
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		loadRespFile(w, "api/snapshot/relations.json")
+	})
+	defer server.Close()
+	res, err := client.ViewSnapshot(&ViewSnapshotRequest{Query: "type = 'service'"})
+	require.NoError(t, err)
+	require.True(t, res.Success)
+	assert.Len(t, res.Components, 3)
+	assert.Equal(t, "DEVIATING", res.Components[1].State.HealthState)
+
+	require.Len(t, res.Relations, 2)
+	rel := res.Relations[0]
+	assert.Equal(t, int64(1001), rel.ID)
+	assert.Equal(t, int64(30), rel.Type)
+	assert.Equal(t, int64(1), rel.Source)
+	assert.Equal(t, int64(2), rel.Target)
+	assert.Equal(t, DependencyOneWay, rel.DependencyDirection)
+	assert.Equal(t, "CLEAR", rel.State.HealthState)
+	assert.Equal(t, DependencyBoth, res.Relations[1].DependencyDirection)
+
+	assert.Equal(t, []ViewIndirectRelation{{Source: 1, Target: 3, DependencyDirection: DependencyOneWay, InternalType: "ViewIndirectRelation"}}, res.IndirectRelations)
+	require.Len(t, res.Groups, 1)
+	assert.Equal(t, []int64{1, 2}, res.Groups[0].Components)
+	require.Len(t, res.GroupRelations, 1)
+	assert.Equal(t, []int64{1002}, res.GroupRelations[0].Relations)
+}
+
 func TestQuery(t *testing.T) {
 	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/api/metrics/query", r.URL.Path)
@@ -149,6 +203,139 @@ func TestQueryRange(t *testing.T) {
 	assert.Equal(t, "success", response.Status)
 }
 
+func TestCancelledContextAbortsCall(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	defer server.Close()
+	defer close(release)
+
+	calls := map[string]func(ctx context.Context) error{
+		"Status": func(ctx context.Context) error {
+			_, err := client.StatusCtx(ctx)
+			return err
+		},
+		"GetTrace": func(ctx context.Context) error {
+			_, err := client.GetTraceCtx(ctx, "xxx")
+			return err
+		},
+		"QueryMetric": func(ctx context.Context) error {
+			_, err := client.QueryMetricCtx(ctx, "up", time.Now(), DefaultTimeout)
+			return err
+		},
+		"SnapShotTopologyQuery": func(ctx context.Context) error {
+			_, err := client.SnapShotTopologyQueryCtx(ctx, "type = 'pod'")
+			return err
+		},
+		"TopologyQuery": func(ctx context.Context) error {
+			_, err := client.TopologyQueryCtx(ctx, "type = 'pod'", "", false)
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-started
+				cancel()
+			}()
+			err := call(ctx)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+}
+
+func TestClientTLSVerification(t *testing.T) {
+	conf := getConfig(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/server/info", r.URL.Path)
+		_, _ = w.Write([]byte(`{"version":{"major":6}}`))
+	}))
+	defer server.Close()
+	conf.ApiUrl = server.URL
+
+	_, err := NewClient(conf).Status()
+	require.Error(t, err, "self-signed certificate must be rejected by default")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	status, err := NewClient(conf, sts.WithRootCAs(pool)).Status()
+	require.NoError(t, err)
+	assert.Equal(t, 6, status.Version.Major)
+
+	_, err = NewClient(conf, sts.WithInsecureSkipVerify()).Status()
+	require.NoError(t, err)
+
+	_, err = NewClient(conf, sts.WithHTTPClient(server.Client())).Status()
+	require.NoError(t, err)
+}
+
+func TestAPIErrors(t *testing.T) {
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/server/info":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/api/traces/xxx":
+			w.WriteHeader(http.StatusNotFound)
+		case "/api/metrics/query":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/api/script":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"The supplied authentication is not authorized to access this resource","errorCode":403}]}`))
+		case "/api/snapshot":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"first","errorCode":1},{"message":"second","errorCode":2}]}`))
+		}
+	})
+	defer server.Close()
+
+	_, err := client.Status()
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = client.GetTrace("xxx")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = client.QueryMetric("up", time.Now(), DefaultTimeout)
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	res, err := client.TopologyQuery("type = 'pod'", "", false)
+	require.NoError(t, err)
+	assert.False(t, res.Success)
+	assert.ErrorIs(t, res.Err(), ErrForbiddenScript)
+	assert.ErrorIs(t, res.Err(), ErrForbidden)
+
+	_, err = client.SnapShotTopologyQuery("type = 'pod'")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "snapshot", apiErr.Endpoint)
+	assert.Equal(t, 1, apiErr.ErrorCode)
+	assert.Len(t, apiErr.Errors, 2)
+	assert.Contains(t, string(apiErr.Body), "second")
+	assert.NotErrorIs(t, err, ErrForbidden)
+}
+
+func TestQueryRangeExpr(t *testing.T) {
+	query := promql.Sum(promql.Metric("kubernetes_state_node_count", promql.Eq("cluster_name", "susecon-frb-cluster-0"))).By("cluster_name")
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/metrics/query_range", r.URL.Path)
+		assert.Equal(t, `sum by (cluster_name) (kubernetes_state_node_count{cluster_name="susecon-frb-cluster-0"})`, r.URL.Query().Get("query"))
+		assert.Equal(t, "1m30s", r.URL.Query().Get("step"))
+		assert.Equal(t, "10s", r.URL.Query().Get("timeout"))
+		loadRespFile(w, "api/metrics/query_range/response.json")
+	})
+	defer server.Close()
+	now := time.Now()
+	response, err := client.QueryRangeMetricExpr(context.Background(), query, now.Add(-5*time.Minute), now, 90*time.Second, 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "success", response.Status)
+}
+
 func TestClientConnection(t *testing.T) {
 	conf := getConfig(t)
 	client := NewClient(conf)
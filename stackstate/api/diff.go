@@ -0,0 +1,308 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// TopologyDiff lists what changed between two topology results. Components and relations are
+// matched by id and every list is ordered by id.
+type TopologyDiff struct {
+	AddedComponents   []ComponentRef    `json:"addedComponents,omitempty"`
+	RemovedComponents []ComponentRef    `json:"removedComponents,omitempty"`
+	ChangedComponents []ComponentChange `json:"changedComponents,omitempty"`
+	AddedRelations    []RelationRef     `json:"addedRelations,omitempty"`
+	RemovedRelations  []RelationRef     `json:"removedRelations,omitempty"`
+	ChangedRelations  []RelationChange  `json:"changedRelations,omitempty"`
+}
+
+type ComponentRef struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type RelationRef struct {
+	ID     int64 `json:"id"`
+	Type   int64 `json:"type"`
+	Source int64 `json:"source"`
+	Target int64 `json:"target"`
+}
+
+// HealthTransition is a change of health state, e.g. CLEAR to DEVIATING.
+type HealthTransition struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// ValueChange is a changed value. A value that was added has no Before, one that was removed no After.
+type ValueChange struct {
+	Key    string `json:"key"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+type ComponentChange struct {
+	ComponentRef
+	Health             *HealthTransition `json:"health,omitempty"`
+	Properties         []ValueChange     `json:"properties,omitempty"`
+	AddedLabels        []string          `json:"addedLabels,omitempty"`
+	RemovedLabels      []string          `json:"removedLabels,omitempty"`
+	AddedIdentifiers   []string          `json:"addedIdentifiers,omitempty"`
+	RemovedIdentifiers []string          `json:"removedIdentifiers,omitempty"`
+}
+
+// RelationChange reports a relation that kept its id. Changes holds the other changed fields,
+// keyed name, type, source, target and dependencyDirection.
+type RelationChange struct {
+	RelationRef
+	Health  *HealthTransition `json:"health,omitempty"`
+	Changes []ValueChange     `json:"changes,omitempty"`
+}
+
+// DiffSnapshots compares two view snapshots, e.g. of the same query at two points in time.
+func DiffSnapshots(before, after *ViewSnapshotResponse) *TopologyDiff {
+	return DiffGraphs(GraphFromSnapshot(before), GraphFromSnapshot(after))
+}
+
+// DiffComponents compares two topology script results. These have no relations.
+func DiffComponents(before, after []SyncComponent) *TopologyDiff {
+	return DiffGraphs(GraphFromComponents(before, nil), GraphFromComponents(after, nil))
+}
+
+// DiffGraphs compares two graphs. Components that are only known as the end of a relation are
+// not compared.
+func DiffGraphs(before, after *Graph) *TopologyDiff {
+	d := &TopologyDiff{}
+	for _, n := range after.Nodes() {
+		if n.placeholder {
+			continue
+		}
+		old, ok := before.Node(n.ID)
+		if !ok || old.placeholder {
+			d.AddedComponents = append(d.AddedComponents, ComponentRef{ID: n.ID, Name: n.Name})
+			continue
+		}
+		if change, changed := diffNode(old, n); changed {
+			d.ChangedComponents = append(d.ChangedComponents, change)
+		}
+	}
+	for _, n := range before.Nodes() {
+		if n.placeholder {
+			continue
+		}
+		if current, ok := after.Node(n.ID); !ok || current.placeholder {
+			d.RemovedComponents = append(d.RemovedComponents, ComponentRef{ID: n.ID, Name: n.Name})
+		}
+	}
+	for _, e := range after.Edges() {
+		old, ok := before.Edge(e.ID)
+		if !ok {
+			d.AddedRelations = append(d.AddedRelations, relationRef(e))
+			continue
+		}
+		if change, changed := diffEdge(old, e); changed {
+			d.ChangedRelations = append(d.ChangedRelations, change)
+		}
+	}
+	for _, e := range before.Edges() {
+		if _, ok := after.Edge(e.ID); !ok {
+			d.RemovedRelations = append(d.RemovedRelations, relationRef(e))
+		}
+	}
+	return d
+}
+
+// Empty reports whether nothing changed.
+func (d *TopologyDiff) Empty() bool {
+	return len(d.AddedComponents) == 0 && len(d.RemovedComponents) == 0 && len(d.ChangedComponents) == 0 &&
+		len(d.AddedRelations) == 0 && len(d.RemovedRelations) == 0 && len(d.ChangedRelations) == 0
+}
+
+// JSON returns the diff as indented JSON for change reviews.
+func (d *TopologyDiff) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// String returns a human-readable report with one line per element, starting with + when it was
+// added, - when it was removed and ~ when it changed, e.g.
+// "~ component 2 checkout: health CLEAR -> DEVIATING, property podPhase Running -> Failed".
+func (d *TopologyDiff) String() string {
+	if d.Empty() {
+		return "no changes\n"
+	}
+	var b strings.Builder
+	for _, c := range d.AddedComponents {
+		fmt.Fprintf(&b, "+ component %d %s\n", c.ID, c.Name)
+	}
+	for _, c := range d.RemovedComponents {
+		fmt.Fprintf(&b, "- component %d %s\n", c.ID, c.Name)
+	}
+	for _, c := range d.ChangedComponents {
+		var parts []string
+		if c.Health != nil {
+			parts = append(parts, "health "+c.Health.Before+" -> "+c.Health.After)
+		}
+		for _, p := range c.Properties {
+			parts = append(parts, "property "+p.String())
+		}
+		parts = append(parts, prefixed("label +", c.AddedLabels)...)
+		parts = append(parts, prefixed("label -", c.RemovedLabels)...)
+		parts = append(parts, prefixed("identifier +", c.AddedIdentifiers)...)
+		parts = append(parts, prefixed("identifier -", c.RemovedIdentifiers)...)
+		fmt.Fprintf(&b, "~ component %d %s: %s\n", c.ID, c.Name, strings.Join(parts, ", "))
+	}
+	for _, r := range d.AddedRelations {
+		fmt.Fprintf(&b, "+ relation %d %d -> %d\n", r.ID, r.Source, r.Target)
+	}
+	for _, r := range d.RemovedRelations {
+		fmt.Fprintf(&b, "- relation %d %d -> %d\n", r.ID, r.Source, r.Target)
+	}
+	for _, r := range d.ChangedRelations {
+		var parts []string
+		if r.Health != nil {
+			parts = append(parts, "health "+r.Health.Before+" -> "+r.Health.After)
+		}
+		for _, c := range r.Changes {
+			parts = append(parts, c.String())
+		}
+		fmt.Fprintf(&b, "~ relation %d %d -> %d: %s\n", r.ID, r.Source, r.Target, strings.Join(parts, ", "))
+	}
+	return b.String()
+}
+
+func (c ValueChange) String() string {
+	return fmt.Sprintf("%s %s -> %s", c.Key, orNone(c.Before), orNone(c.After))
+}
+
+func orNone(v string) string {
+	if v == "" {
+		return "(none)"
+	}
+	return v
+}
+
+func prefixed(prefix string, values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		result = append(result, prefix+v)
+	}
+	return result
+}
+
+func diffNode(before, after *GraphNode) (ComponentChange, bool) {
+	c := ComponentChange{ComponentRef: ComponentRef{ID: after.ID, Name: after.Name}}
+	if before.HealthState != after.HealthState {
+		c.Health = &HealthTransition{Before: before.HealthState, After: after.HealthState}
+	}
+	c.Properties = diffValues(nodeProperties(before), nodeProperties(after))
+	if before.Name != after.Name {
+		c.Properties = append([]ValueChange{{Key: "name", Before: before.Name, After: after.Name}}, c.Properties...)
+	}
+	c.AddedLabels, c.RemovedLabels = diffSets(nodeLabels(before), nodeLabels(after))
+	c.AddedIdentifiers, c.RemovedIdentifiers = diffSets(before.Identifiers, after.Identifiers)
+	changed := c.Health != nil || len(c.Properties) > 0 || len(c.AddedLabels) > 0 || len(c.RemovedLabels) > 0 ||
+		len(c.AddedIdentifiers) > 0 || len(c.RemovedIdentifiers) > 0
+	return c, changed
+}
+
+func diffEdge(before, after *GraphEdge) (RelationChange, bool) {
+	c := RelationChange{RelationRef: relationRef(after)}
+	if b, a := edgeHealth(before), edgeHealth(after); b != a {
+		c.Health = &HealthTransition{Before: b, After: a}
+	}
+	c.Changes = diffValues(edgeValues(before), edgeValues(after))
+	return c, c.Health != nil || len(c.Changes) > 0
+}
+
+func relationRef(e *GraphEdge) RelationRef {
+	return RelationRef{ID: e.ID, Type: e.Type, Source: e.Source, Target: e.Target}
+}
+
+func edgeHealth(e *GraphEdge) string {
+	if e.Relation == nil {
+		return ""
+	}
+	return e.Relation.State.HealthState
+}
+
+func edgeValues(e *GraphEdge) map[string]string {
+	values := map[string]string{
+		"type":                fmt.Sprint(e.Type),
+		"source":              fmt.Sprint(e.Source),
+		"target":              fmt.Sprint(e.Target),
+		"dependencyDirection": string(e.Direction),
+	}
+	if e.Relation != nil {
+		values["name"] = e.Relation.Name
+	}
+	return values
+}
+
+func nodeProperties(n *GraphNode) map[string]string {
+	switch {
+	case n.View != nil:
+		return n.View.Properties
+	case n.Sync != nil:
+		props := make(map[string]string, len(n.Sync.Properties))
+		for k, v := range n.Sync.Properties {
+			if s, ok := v.(string); ok {
+				props[k] = s
+			} else {
+				b, _ := json.Marshal(v)
+				props[k] = string(b)
+			}
+		}
+		return props
+	}
+	return nil
+}
+
+func nodeLabels(n *GraphNode) []string {
+	switch {
+	case n.View != nil:
+		return n.View.Tags
+	case n.Sync != nil:
+		labels := slices.Clone(n.Sync.Tags)
+		for _, l := range n.Sync.Labels {
+			labels = append(labels, string(l))
+		}
+		return labels
+	}
+	return nil
+}
+
+func diffValues(before, after map[string]string) []ValueChange {
+	var changes []ValueChange
+	for _, k := range slices.Sorted(maps.Keys(after)) {
+		if old, ok := before[k]; !ok || old != after[k] {
+			changes = append(changes, ValueChange{Key: k, Before: old, After: after[k]})
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(before)) {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, ValueChange{Key: k, Before: before[k]})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b ValueChange) int { return cmp.Compare(a.Key, b.Key) })
+	return changes
+}
+
+func diffSets(before, after []string) (added, removed []string) {
+	for _, v := range after {
+		if !slices.Contains(before, v) && !slices.Contains(added, v) {
+			added = append(added, v)
+		}
+	}
+	for _, v := range before {
+		if !slices.Contains(after, v) && !slices.Contains(removed, v) {
+			removed = append(removed, v)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	return added, removed
+}
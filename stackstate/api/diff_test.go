@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadSnapshot(t *testing.T, file string) *ViewSnapshotResponse {
+	b, err := os.ReadFile("../../testdata/api/snapshot/" + file)
+	require.NoError(t, err)
+	var res querySnapshotResult
+	require.NoError(t, json.Unmarshal(b, &res))
+	return &res.ViewSnapshotResponse
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	before := loadSnapshot(t, "relations.json")
+	after := loadSnapshot(t, "relations.json")
+
+	assert.True(t, DiffSnapshots(before, after).Empty())
+
+	// checkout recovers and moves, postgres is replaced by a new component
+	checkout := &after.Components[1]
+	checkout.State.HealthState = "CLEAR"
+	checkout.Properties = map[string]string{"podPhase": "Running"}
+	checkout.Tags = []string{"team:shop"}
+	checkout.Identifiers = []string{"urn:service/checkout-v2"}
+	after.Components[2] = ViewComponent{ID: 4, Name: "mysql", Identifiers: []string{"urn:database/mysql"}}
+	after.Relations[1].Target = 4
+	after.Relations[1].ID = 1003
+	after.Relations[0].DependencyDirection = DependencyBoth
+	after.Relations[0].State.HealthState = "CRITICAL"
+
+	d := DiffSnapshots(before, after)
+	assert.Equal(t, []ComponentRef{{ID: 4, Name: "mysql"}}, d.AddedComponents)
+	assert.Equal(t, []ComponentRef{{ID: 3, Name: "postgres"}}, d.RemovedComponents)
+	require.Len(t, d.ChangedComponents, 1)
+	assert.Equal(t, ComponentChange{
+		ComponentRef:       ComponentRef{ID: 2, Name: "checkout"},
+		Health:             &HealthTransition{Before: "DEVIATING", After: "CLEAR"},
+		Properties:         []ValueChange{{Key: "podPhase", After: "Running"}},
+		AddedLabels:        []string{"team:shop"},
+		AddedIdentifiers:   []string{"urn:service/checkout-v2"},
+		RemovedIdentifiers: []string{"urn:service/checkout"},
+	}, d.ChangedComponents[0])
+	assert.Equal(t, []RelationRef{{ID: 1003, Type: 31, Source: 2, Target: 4}}, d.AddedRelations)
+	assert.Equal(t, []RelationRef{{ID: 1002, Type: 31, Source: 2, Target: 3}}, d.RemovedRelations)
+	require.Len(t, d.ChangedRelations, 1)
+	assert.Equal(t, &HealthTransition{Before: "CLEAR", After: "CRITICAL"}, d.ChangedRelations[0].Health)
+	assert.Equal(t, []ValueChange{{Key: "dependencyDirection", Before: "ONE_WAY", After: "BOTH"}}, d.ChangedRelations[0].Changes)
+
+	assert.Equal(t, `+ component 4 mysql
+- component 3 postgres
+~ component 2 checkout: health DEVIATING -> CLEAR, property podPhase (none) -> Running, label +team:shop, identifier +urn:service/checkout-v2, identifier -urn:service/checkout
++ relation 1003 2 -> 4
+- relation 1002 2 -> 3
+~ relation 1001 1 -> 2: health CLEAR -> CRITICAL, dependencyDirection ONE_WAY -> BOTH
+`, d.String())
+
+	b, err := d.JSON()
+	require.NoError(t, err)
+	var decoded TopologyDiff
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, *d, decoded)
+	assert.Contains(t, string(b), `"removedComponents": [`)
+	assert.NotContains(t, string(b), "changedRelations\": null")
+}
+
+func TestDiffComponents(t *testing.T) {
+	before := []SyncComponent{
+		{Id: 1, Name: "a", Labels: []Label{"env:prod"}, Properties: map[string]interface{}{"replicas": 2.0, "image": "a:1"}, State: map[string]interface{}{"healthState": "CLEAR"}},
+		{Id: 2, Name: "b"},
+	}
+	after := []SyncComponent{
+		{Id: 1, Name: "a", Labels: []Label{"env:staging"}, Properties: map[string]interface{}{"replicas": 3.0}, State: map[string]interface{}{"healthState": "CLEAR"}},
+	}
+	d := DiffComponents(before, after)
+	assert.Equal(t, []ComponentRef{{ID: 2, Name: "b"}}, d.RemovedComponents)
+	require.Len(t, d.ChangedComponents, 1)
+	c := d.ChangedComponents[0]
+	assert.Nil(t, c.Health)
+	assert.Equal(t, []ValueChange{{Key: "image", Before: "a:1"}, {Key: "replicas", Before: "2", After: "3"}}, c.Properties)
+	assert.Equal(t, []string{"env:staging"}, c.AddedLabels)
+	assert.Equal(t, []string{"env:prod"}, c.RemovedLabels)
+	assert.Empty(t, d.AddedRelations)
+	assert.Equal(t, "no changes\n", DiffComponents(after, after).String())
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrForbiddenScript = errors.New("not authorized to execute scripts")
+	ErrNotFound        = errors.New("not found")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrInvalidAt       = errors.New("invalid at value")
+)
+
+// maxErrorBody limits how much of an error response is kept in APIError.Body.
+const maxErrorBody = 1 << 20
+
+// APIError is returned when the StackState api answers with a non-success status
+// or reports errors in the response body.
+// Use errors.Is with the Err* sentinels to branch on the kind of failure.
+type APIError struct {
+	StatusCode int
+	Endpoint   string
+	ErrorCode  int // code of the first reported error, if any
+	Errors     []*ErrorMsg
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	var msgs []string
+	for _, m := range e.Errors {
+		msgs = append(msgs, m.Message)
+	}
+	status := http.StatusText(e.StatusCode)
+	if e.StatusCode == 0 {
+		status = "request failed"
+	}
+	if len(msgs) == 0 {
+		return fmt.Sprintf("stackstate api %s: %s", e.Endpoint, status)
+	}
+	return fmt.Sprintf("stackstate api %s: %s: %s", e.Endpoint, status, strings.Join(msgs, "; "))
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden || e.notAuthorized()
+	case ErrForbiddenScript:
+		return e.Endpoint == "script" && (e.StatusCode == http.StatusForbidden || e.notAuthorized())
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// notAuthorized reports whether the server refused the supplied authentication
+// for this resource, which it may do without a 403 status.
+func (e *APIError) notAuthorized() bool {
+	for _, m := range e.Errors {
+		if strings.Contains(strings.ToLower(m.Message), "not authorized to access this resource") {
+			return true
+		}
+	}
+	return false
+}
+
+func newAPIError(endpoint string, statusCode int, errs []*ErrorMsg) *APIError {
+	e := &APIError{StatusCode: statusCode, Endpoint: endpoint, Errors: errs}
+	if len(errs) > 0 {
+		e.ErrorCode = errs[0].ErrorCode
+	}
+	return e
+}
+
+// checkStatus converts any non 2xx response into an *APIError.
+func checkStatus(endpoint string) func(*http.Response) error {
+	return func(res *http.Response) error {
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			return nil
+		}
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+		var e ErrorResp
+		_ = json.Unmarshal(body, &e)
+		apiErr := newAPIError(endpoint, res.StatusCode, e.Errors)
+		apiErr.Body = body
+		return apiErr
+	}
+}
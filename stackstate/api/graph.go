@@ -0,0 +1,391 @@
+package api
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+// GraphNode is a component in a Graph. View or Sync holds the component it was built from.
+type GraphNode struct {
+	ID          int64
+	Name        string
+	Type        int64
+	Layer       int
+	Domain      int
+	Identifiers []string
+	HealthState string
+	View        *ViewComponent
+	Sync        *SyncComponent
+	placeholder bool // only known as the end of a relation
+}
+
+// GraphEdge is a relation in a Graph. Relation is set when it was built from a view snapshot.
+type GraphEdge struct {
+	ID        int64
+	Type      int64
+	Source    int64
+	Target    int64
+	Direction DependencyDirection
+	Relation  *ViewRelation
+}
+
+// Reached is a node found by a traversal, Distance relations away from where it started.
+type Reached struct {
+	Node     *GraphNode
+	Distance int
+}
+
+// Graph is an in-memory topology. Relations follow the dependency direction: with ONE_WAY the
+// source depends on the target, with BOTH each depends on the other and NONE relations only
+// connect components. Relations without a direction are treated as ONE_WAY.
+// A Graph is not safe for concurrent modification.
+type Graph struct {
+	nodes        map[int64]*GraphNode
+	byIdentifier map[string]*GraphNode
+	edges        map[int64]*GraphEdge
+	out          map[int64][]*GraphEdge
+	in           map[int64][]*GraphEdge
+}
+
+func NewGraph() *Graph {
+	return &Graph{
+		nodes:        make(map[int64]*GraphNode),
+		byIdentifier: make(map[string]*GraphNode),
+		edges:        make(map[int64]*GraphEdge),
+		out:          make(map[int64][]*GraphEdge),
+		in:           make(map[int64][]*GraphEdge),
+	}
+}
+
+// GraphFromSnapshot builds a graph of the components and relations of a view snapshot.
+func GraphFromSnapshot(res *ViewSnapshotResponse) *Graph {
+	g := NewGraph()
+	for i := range res.Components {
+		c := &res.Components[i]
+		g.AddNode(GraphNode{
+			ID:          c.ID,
+			Name:        c.Name,
+			Type:        c.Type,
+			Layer:       c.Layer,
+			Domain:      c.Domain,
+			Identifiers: c.Identifiers,
+			HealthState: c.State.HealthState,
+			View:        c,
+		})
+	}
+	for i := range res.Relations {
+		r := &res.Relations[i]
+		g.AddEdge(GraphEdge{ID: r.ID, Type: r.Type, Source: r.Source, Target: r.Target, Direction: r.DependencyDirection, Relation: r})
+	}
+	return g
+}
+
+// GraphFromComponents builds a graph of topology script results. Scripts return no relations,
+// so these are passed separately, e.g. from a view snapshot of the same query.
+func GraphFromComponents(components []SyncComponent, relations []ViewRelation) *Graph {
+	g := NewGraph()
+	for i := range components {
+		c := &components[i]
+		health, _ := c.State["healthState"].(string)
+		g.AddNode(GraphNode{
+			ID:          int64(c.Id),
+			Name:        c.Name,
+			Layer:       c.Layer,
+			Domain:      c.Domain,
+			Identifiers: c.Identifiers,
+			HealthState: health,
+			Sync:        c,
+		})
+	}
+	for i := range relations {
+		r := &relations[i]
+		g.AddEdge(GraphEdge{ID: r.ID, Type: r.Type, Source: r.Source, Target: r.Target, Direction: r.DependencyDirection, Relation: r})
+	}
+	return g
+}
+
+// AddNode adds a node or replaces the node with the same id.
+func (g *Graph) AddNode(n GraphNode) *GraphNode {
+	node, ok := g.nodes[n.ID]
+	if ok {
+		for _, id := range node.Identifiers {
+			delete(g.byIdentifier, id)
+		}
+		*node = n
+	} else {
+		node = &n
+		g.nodes[n.ID] = node
+	}
+	for _, id := range node.Identifiers {
+		g.byIdentifier[id] = node
+	}
+	return node
+}
+
+// AddEdge adds a relation or replaces the relation with the same id. Components at either end
+// that are not in the graph yet are added with only their id.
+func (g *Graph) AddEdge(e GraphEdge) *GraphEdge {
+	if old, ok := g.edges[e.ID]; ok {
+		g.out[old.Source] = slices.DeleteFunc(g.out[old.Source], func(x *GraphEdge) bool { return x == old })
+		g.in[old.Target] = slices.DeleteFunc(g.in[old.Target], func(x *GraphEdge) bool { return x == old })
+	}
+	for _, id := range []int64{e.Source, e.Target} {
+		if _, ok := g.nodes[id]; !ok {
+			g.nodes[id] = &GraphNode{ID: id, placeholder: true}
+		}
+	}
+	edge := &e
+	g.edges[e.ID] = edge
+	g.out[e.Source] = append(g.out[e.Source], edge)
+	g.in[e.Target] = append(g.in[e.Target], edge)
+	return edge
+}
+
+func (g *Graph) Node(id int64) (*GraphNode, bool) {
+	n, ok := g.nodes[id]
+	return n, ok
+}
+
+// NodeByIdentifier finds a node by any of its identifiers, e.g. a URN.
+func (g *Graph) NodeByIdentifier(identifier string) (*GraphNode, bool) {
+	n, ok := g.byIdentifier[identifier]
+	return n, ok
+}
+
+func (g *Graph) Edge(id int64) (*GraphEdge, bool) {
+	e, ok := g.edges[id]
+	return e, ok
+}
+
+// Nodes returns all nodes ordered by id.
+func (g *Graph) Nodes() []*GraphNode {
+	return sortedByID(g.nodes, func(n *GraphNode) int64 { return n.ID })
+}
+
+// Edges returns all relations ordered by id.
+func (g *Graph) Edges() []*GraphEdge {
+	return sortedByID(g.edges, func(e *GraphEdge) int64 { return e.ID })
+}
+
+// Dependencies returns the components id directly depends on.
+func (g *Graph) Dependencies(id int64) []int64 {
+	var ids []int64
+	for _, e := range g.out[id] {
+		if e.Direction != DependencyNone {
+			ids = append(ids, e.Target)
+		}
+	}
+	for _, e := range g.in[id] {
+		if e.Direction == DependencyBoth {
+			ids = append(ids, e.Source)
+		}
+	}
+	return uniqueSorted(ids)
+}
+
+// Dependents returns the components that directly depend on id.
+func (g *Graph) Dependents(id int64) []int64 {
+	var ids []int64
+	for _, e := range g.in[id] {
+		if e.Direction != DependencyNone {
+			ids = append(ids, e.Source)
+		}
+	}
+	for _, e := range g.out[id] {
+		if e.Direction == DependencyBoth {
+			ids = append(ids, e.Target)
+		}
+	}
+	return uniqueSorted(ids)
+}
+
+// neighbours returns the components connected to id by any relation.
+func (g *Graph) neighbours(id int64) []int64 {
+	var ids []int64
+	for _, e := range g.out[id] {
+		ids = append(ids, e.Target)
+	}
+	for _, e := range g.in[id] {
+		ids = append(ids, e.Source)
+	}
+	return uniqueSorted(ids)
+}
+
+// Downstream returns everything id depends on, directly or transitively, nearest first.
+func (g *Graph) Downstream(id int64) []Reached {
+	return g.reach(id, g.Dependencies)
+}
+
+// Upstream returns everything that depends on id, directly or transitively, nearest first.
+func (g *Graph) Upstream(id int64) []Reached {
+	return g.reach(id, g.Dependents)
+}
+
+// Impact is the blast radius of a failing component: the components that depend on it and
+// the relations they depend on it through.
+type Impact struct {
+	Source     int64
+	Components []Reached
+	Relations  []*GraphEdge
+}
+
+// Impact returns what is affected when id fails.
+func (g *Graph) Impact(id int64) Impact {
+	impact := Impact{Source: id, Components: g.Upstream(id)}
+	affected := map[int64]bool{id: true}
+	for _, r := range impact.Components {
+		affected[r.Node.ID] = true
+	}
+	for _, e := range g.Edges() {
+		if e.Direction != DependencyNone && affected[e.Source] && affected[e.Target] {
+			impact.Relations = append(impact.Relations, e)
+		}
+	}
+	return impact
+}
+
+func (g *Graph) reach(start int64, next func(int64) []int64) []Reached {
+	var result []Reached
+	seen := map[int64]bool{start: true}
+	frontier := []int64{start}
+	for distance := 1; len(frontier) > 0; distance++ {
+		var following []int64
+		for _, id := range frontier {
+			for _, n := range next(id) {
+				if seen[n] {
+					continue
+				}
+				seen[n] = true
+				following = append(following, n)
+				result = append(result, Reached{Node: g.nodes[n], Distance: distance})
+			}
+		}
+		frontier = following
+	}
+	return result
+}
+
+// ShortestPath returns the ids on the shortest dependency chain from one component to another,
+// including both ends, or nil when from does not depend on to.
+func (g *Graph) ShortestPath(from, to int64) []int64 {
+	if _, ok := g.nodes[from]; !ok {
+		return nil
+	}
+	if from == to {
+		return []int64{from}
+	}
+	previous := map[int64]int64{from: from}
+	queue := []int64{from}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, n := range g.Dependencies(id) {
+			if _, ok := previous[n]; ok {
+				continue
+			}
+			previous[n] = id
+			if n == to {
+				path := []int64{to}
+				for p := id; p != from; p = previous[p] {
+					path = append(path, p)
+				}
+				path = append(path, from)
+				slices.Reverse(path)
+				return path
+			}
+			queue = append(queue, n)
+		}
+	}
+	return nil
+}
+
+// ConnectedComponents groups the component ids that are connected by any relation,
+// ignoring direction. Groups and the ids in them are ordered.
+func (g *Graph) ConnectedComponents() [][]int64 {
+	var groups [][]int64
+	seen := make(map[int64]bool)
+	for _, n := range g.Nodes() {
+		if seen[n.ID] {
+			continue
+		}
+		seen[n.ID] = true
+		group := []int64{n.ID}
+		for i := 0; i < len(group); i++ {
+			for _, m := range g.neighbours(group[i]) {
+				if !seen[m] {
+					seen[m] = true
+					group = append(group, m)
+				}
+			}
+		}
+		slices.Sort(group)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Cycles returns the groups of components that depend on each other, directly or transitively.
+// BOTH relations make their two components a cycle.
+func (g *Graph) Cycles() [][]int64 {
+	// Tarjan's strongly connected components
+	index := make(map[int64]int)
+	low := make(map[int64]int)
+	onStack := make(map[int64]bool)
+	var stack []int64
+	var cycles [][]int64
+	var visit func(id int64)
+	visit = func(id int64) {
+		index[id] = len(index)
+		low[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+		selfLoop := false
+		for _, n := range g.Dependencies(id) {
+			if n == id {
+				selfLoop = true
+			}
+			if _, ok := index[n]; !ok {
+				visit(n)
+				low[id] = min(low[id], low[n])
+			} else if onStack[n] {
+				low[id] = min(low[id], index[n])
+			}
+		}
+		if low[id] != index[id] {
+			return
+		}
+		var scc []int64
+		for {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[n] = false
+			scc = append(scc, n)
+			if n == id {
+				break
+			}
+		}
+		if len(scc) > 1 || selfLoop {
+			slices.Sort(scc)
+			cycles = append(cycles, scc)
+		}
+	}
+	for _, n := range g.Nodes() {
+		if _, ok := index[n.ID]; !ok {
+			visit(n.ID)
+		}
+	}
+	slices.SortFunc(cycles, func(a, b []int64) int { return cmp.Compare(a[0], b[0]) })
+	return cycles
+}
+
+func sortedByID[T any](m map[int64]T, id func(T) int64) []T {
+	values := slices.Collect(maps.Values(m))
+	slices.SortFunc(values, func(a, b T) int { return cmp.Compare(id(a), id(b)) })
+	return values
+}
+
+func uniqueSorted(ids []int64) []int64 {
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGraph builds a graph from ONE_WAY relations given as source, target pairs.
+func testGraph(pairs ...[2]int64) *Graph {
+	g := NewGraph()
+	for i, p := range pairs {
+		g.AddEdge(GraphEdge{ID: int64(1000 + i), Source: p[0], Target: p[1], Direction: DependencyOneWay})
+	}
+	return g
+}
+
+func reachedIDs(reached []Reached) []int64 {
+	ids := make([]int64, 0, len(reached))
+	for _, r := range reached {
+		ids = append(ids, r.Node.ID)
+	}
+	return ids
+}
+
+func TestGraphFromSnapshot(t *testing.T) {
+	g := GraphFromSnapshot(loadSnapshot(t, "relations.json"))
+
+	assert.Len(t, g.Nodes(), 3)
+	assert.Len(t, g.Edges(), 2)
+	n, ok := g.NodeByIdentifier("urn:service/checkout")
+	require.True(t, ok)
+	assert.Equal(t, int64(2), n.ID)
+	assert.Equal(t, "DEVIATING", n.HealthState)
+	assert.Equal(t, "checkout", n.View.Name)
+
+	// frontend -> checkout is ONE_WAY, checkout <-> postgres is BOTH
+	assert.Equal(t, []int64{2, 3}, reachedIDs(g.Downstream(1)))
+	assert.Equal(t, []int64{2, 1}, reachedIDs(g.Upstream(3)))
+	assert.Equal(t, []int64{1, 2, 3}, g.ShortestPath(1, 3))
+	assert.Equal(t, []int64{3, 2}, g.ShortestPath(3, 2))
+	assert.Nil(t, g.ShortestPath(3, 1))
+	assert.Equal(t, [][]int64{{2, 3}}, g.Cycles())
+}
+
+func TestGraphTraversal(t *testing.T) {
+	// a pod runs on a node and uses a volume, a service and a deployment depend on the pod
+	g := testGraph([2]int64{10, 1}, [2]int64{11, 1}, [2]int64{1, 20}, [2]int64{1, 21}, [2]int64{12, 10})
+
+	downstream := g.Downstream(12)
+	assert.Equal(t, []int64{10, 1, 20, 21}, reachedIDs(downstream))
+	assert.Equal(t, []int{1, 2, 3, 3}, []int{downstream[0].Distance, downstream[1].Distance, downstream[2].Distance, downstream[3].Distance})
+	assert.Equal(t, []int64{10, 11, 12}, reachedIDs(g.Upstream(1)))
+	assert.Equal(t, []int64{1}, g.Dependencies(10))
+	assert.Equal(t, []int64{10, 11}, g.Dependents(1))
+	assert.Equal(t, []int64{12, 10, 1, 21}, g.ShortestPath(12, 21))
+	assert.Empty(t, g.Cycles())
+}
+
+func TestGraphImpact(t *testing.T) {
+	g := testGraph([2]int64{10, 1}, [2]int64{11, 1}, [2]int64{12, 10}, [2]int64{1, 20})
+	g.AddEdge(GraphEdge{ID: 1, Source: 13, Target: 12, Direction: DependencyNone})
+
+	impact := g.Impact(1)
+	assert.Equal(t, []int64{10, 11, 12}, reachedIDs(impact.Components))
+	var relations []int64
+	for _, e := range impact.Relations {
+		relations = append(relations, e.ID)
+	}
+	assert.Equal(t, []int64{1000, 1001, 1002}, relations)
+}
+
+func TestGraphConnectedComponentsAndCycles(t *testing.T) {
+	g := testGraph([2]int64{1, 2}, [2]int64{2, 3}, [2]int64{3, 1}, [2]int64{4, 5}, [2]int64{6, 6})
+	g.AddNode(GraphNode{ID: 7, Name: "lonely"})
+	g.AddEdge(GraphEdge{ID: 1, Source: 5, Target: 8, Direction: DependencyNone})
+
+	assert.Equal(t, [][]int64{{1, 2, 3}, {4, 5, 8}, {6}, {7}}, g.ConnectedComponents())
+	assert.Equal(t, [][]int64{{1, 2, 3}, {6}}, g.Cycles())
+	assert.Nil(t, g.ShortestPath(4, 8))
+}
+
+func TestGraphReplace(t *testing.T) {
+	g := NewGraph()
+	g.AddNode(GraphNode{ID: 1, Identifiers: []string{"urn:a"}})
+	g.AddEdge(GraphEdge{ID: 100, Source: 1, Target: 2})
+	g.AddNode(GraphNode{ID: 1, Identifiers: []string{"urn:b"}})
+	g.AddEdge(GraphEdge{ID: 100, Source: 2, Target: 1})
+
+	_, ok := g.NodeByIdentifier("urn:a")
+	assert.False(t, ok)
+	_, ok = g.NodeByIdentifier("urn:b")
+	assert.True(t, ok)
+	assert.Empty(t, g.Dependencies(1))
+	assert.Equal(t, []int64{1}, g.Dependencies(2))
+}
+
+func TestGraphFromComponents(t *testing.T) {
+	components := []SyncComponent{
+		{Id: 1, Name: "a", Identifiers: []string{"urn:a"}, State: map[string]interface{}{"healthState": "CRITICAL"}},
+		{Id: 2, Name: "b"},
+	}
+	g := GraphFromComponents(components, []ViewRelation{{ID: 7, Source: 1, Target: 2}})
+	n, ok := g.NodeByIdentifier("urn:a")
+	require.True(t, ok)
+	assert.Equal(t, "CRITICAL", n.HealthState)
+	assert.Equal(t, "a", n.Sync.Name)
+	assert.Equal(t, []int64{1}, reachedIDs(g.Upstream(2)))
+}
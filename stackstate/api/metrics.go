@@ -0,0 +1,170 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ravan/stackstate-client/stackstate/promql"
+)
+
+const (
+	// DefaultMaxPointsPerChunk stays below the 11,000 points per series Prometheus compatible servers allow.
+	DefaultMaxPointsPerChunk = 10000
+	DefaultChunkConcurrency  = 4
+)
+
+// RangeQueryOptions controls how QueryRangeMetricChunked splits a range query.
+type RangeQueryOptions struct {
+	MaxPointsPerChunk int           // evaluation steps per chunk, DefaultMaxPointsPerChunk when 0
+	Concurrency       int           // chunks queried at the same time, DefaultChunkConcurrency when 0
+	Timeout           time.Duration // server side timeout of each chunk, DefaultTimeout when 0
+}
+
+// TimeRange is a closed interval of evaluation times.
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// PlanRange splits [start,end] into chunks of at most maxPoints evaluation steps.
+// Every chunk starts on an evaluation time of the full query, so the chunks together produce
+// exactly the points the unsplit query would.
+func PlanRange(start, end time.Time, step time.Duration, maxPoints int) []TimeRange {
+	if step <= 0 || maxPoints <= 0 || end.Before(start) {
+		return []TimeRange{{Start: start, End: end}}
+	}
+	span := step * time.Duration(maxPoints)
+	var chunks []TimeRange
+	for from := start; !from.After(end); from = from.Add(span) {
+		to := from.Add(span - step)
+		if to.After(end) {
+			to = end
+		}
+		chunks = append(chunks, TimeRange{Start: from, End: to})
+	}
+	return chunks
+}
+
+// QueryRangeMetricChunked runs a range query as several smaller range queries, see PlanRange,
+// and merges the results. It fails with the first error of any chunk.
+func (c *Client) QueryRangeMetricChunked(ctx context.Context, query string, start time.Time, end time.Time, step time.Duration, opts RangeQueryOptions) (*MetricQueryResponse, error) {
+	maxPoints := opts.MaxPointsPerChunk
+	if maxPoints <= 0 {
+		maxPoints = DefaultMaxPointsPerChunk
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultChunkConcurrency
+	}
+	timeout := DefaultTimeout
+	if opts.Timeout > 0 {
+		timeout = promql.Duration(opts.Timeout)
+	}
+
+	chunks := PlanRange(start, end, step, maxPoints)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	responses := make([]*MetricQueryResponse, len(chunks))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	running := make(chan struct{}, concurrency)
+	for i, chunk := range chunks {
+		select {
+		case running <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-running }()
+			res, err := c.QueryRangeMetricCtx(ctx, query, chunk.Start, chunk.End, promql.Duration(step), timeout)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			responses[i] = res
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return MergeMetricResponses(responses...), nil
+}
+
+// MergeMetricResponses combines the series of several responses by label set.
+// Points are sorted by timestamp and duplicate timestamps are kept once, as are duplicate warnings and infos.
+func MergeMetricResponses(responses ...*MetricQueryResponse) *MetricQueryResponse {
+	merged := &MetricQueryResponse{Status: "success", Data: MetricData{Result: []MetricResult{}}}
+	index := make(map[string]int)
+	for _, res := range responses {
+		if res == nil {
+			continue
+		}
+		if res.Status != "success" {
+			merged.Status = res.Status
+		}
+		merged.Errors = append(merged.Errors, res.Errors...)
+		merged.Warnings = appendMissing(merged.Warnings, res.Warnings...)
+		merged.Infos = appendMissing(merged.Infos, res.Infos...)
+		if merged.Data.ResultType == "" {
+			merged.Data.ResultType = res.Data.ResultType
+		}
+		for _, r := range res.Data.Result {
+			key := labelKey(r.Labels)
+			i, ok := index[key]
+			if !ok {
+				i = len(merged.Data.Result)
+				index[key] = i
+				merged.Data.Result = append(merged.Data.Result, MetricResult{Labels: r.Labels})
+			}
+			merged.Data.Result[i].Points = append(merged.Data.Result[i].Points, r.Points...)
+		}
+	}
+	for i := range merged.Data.Result {
+		points := merged.Data.Result[i].Points
+		slices.SortStableFunc(points, func(a, b MetricPoint) int {
+			return cmp.Compare(a.Timestamp, b.Timestamp)
+		})
+		merged.Data.Result[i].Points = slices.CompactFunc(points, func(a, b MetricPoint) bool {
+			return a.Timestamp == b.Timestamp
+		})
+	}
+	return merged
+}
+
+func labelKey(labels map[string]string) string {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(labels[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRange(t *testing.T) {
+	start := time.Unix(1000, 0)
+	chunks := PlanRange(start, start.Add(25*time.Minute), time.Minute, 10)
+	require.Len(t, chunks, 3)
+	assert.Equal(t, TimeRange{Start: start, End: start.Add(9 * time.Minute)}, chunks[0])
+	assert.Equal(t, TimeRange{Start: start.Add(10 * time.Minute), End: start.Add(19 * time.Minute)}, chunks[1])
+	assert.Equal(t, TimeRange{Start: start.Add(20 * time.Minute), End: start.Add(25 * time.Minute)}, chunks[2])
+
+	assert.Len(t, PlanRange(start, start.Add(5*time.Minute), time.Minute, 10), 1)
+}
+
+// rangeHandler answers range queries with a point per step for series "a", plus the point after the
+// requested end to simulate overlapping chunks, and a series "b" that only exists in the second half.
+func rangeHandler(t *testing.T, mid int64, inFlight, maxInFlight *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+		}
+		time.Sleep(2 * time.Millisecond)
+
+		start, _ := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		assert.Equal(t, "1m", r.URL.Query().Get("step"))
+		var a, b [][]any
+		for ts := start / 1000; ts <= end/1000+60; ts += 60 {
+			a = append(a, []any{ts, strconv.FormatInt(ts, 10)})
+			if ts >= mid {
+				b = append(b, []any{ts, "1"})
+			}
+		}
+		result := []map[string]any{{"metric": map[string]string{"series": "a"}, "values": a}}
+		if len(b) > 0 {
+			result = append(result, map[string]any{"metric": map[string]string{"series": "b"}, "values": b})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status": "success",
+			"data":   map[string]any{"resultType": "matrix", "result": result},
+		})
+	}
+}
+
+func TestQueryRangeMetricChunked(t *testing.T) {
+	start := time.Unix(1730455440, 0)
+	end := start.Add(95 * time.Minute)
+	mid := start.Add(50 * time.Minute).Unix()
+	var inFlight, maxInFlight atomic.Int32
+	client, server := getClient(t, rangeHandler(t, mid, &inFlight, &maxInFlight))
+	defer server.Close()
+
+	res, err := client.QueryRangeMetricChunked(context.Background(), "up", start, end, time.Minute,
+		RangeQueryOptions{MaxPointsPerChunk: 10, Concurrency: 3})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(3))
+	assert.Equal(t, "success", res.Status)
+	assert.Equal(t, "matrix", res.Data.ResultType)
+	require.Len(t, res.Data.Result, 2)
+
+	a := res.Data.Result[0]
+	assert.Equal(t, "a", a.Labels["series"])
+	// 96 evaluation steps, plus the one extra point the handler adds after the last chunk
+	require.Len(t, a.Points, 97)
+	for i, p := range a.Points {
+		assert.Equal(t, start.Unix()+int64(i)*60, p.Timestamp)
+		assert.Equal(t, float64(p.Timestamp), p.Value)
+	}
+	assert.Equal(t, "b", res.Data.Result[1].Labels["series"])
+	assert.Equal(t, mid, res.Data.Result[1].Points[0].Timestamp)
+}
+
+func TestQueryRangeMetricChunkedFails(t *testing.T) {
+	var calls atomic.Int32
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		loadRespFile(w, "api/metrics/query_range/response.json")
+	})
+	defer server.Close()
+	start := time.Unix(1730455440, 0)
+	_, err := client.QueryRangeMetricChunked(context.Background(), "up", start, start.Add(time.Hour), time.Minute,
+		RangeQueryOptions{MaxPointsPerChunk: 5, Concurrency: 1})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+}
+
+func TestMergeMetricResponsesNotices(t *testing.T) {
+	a := &MetricQueryResponse{Status: "success", Warnings: []string{"w1"}, Infos: []string{"i1"}}
+	b := &MetricQueryResponse{Status: "success", Warnings: []string{"w1", "w2"}}
+	merged := MergeMetricResponses(a, b)
+	assert.Equal(t, []string{"w1", "w2"}, merged.Warnings)
+	assert.Equal(t, []string{"i1"}, merged.Infos)
+}
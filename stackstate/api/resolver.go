@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const DefaultResolverTTL = 10 * time.Minute
+
+// NodeKind is a kind of settings node that topology elements refer to by id.
+type NodeKind string
+
+const (
+	KindLayer         NodeKind = "Layer"
+	KindDomain        NodeKind = "Domain"
+	KindComponentType NodeKind = "ComponentType"
+	KindRelationType  NodeKind = "RelationType"
+)
+
+var nodeKinds = []NodeKind{KindLayer, KindDomain, KindComponentType, KindRelationType}
+
+// Resolver resolves layer, domain, component type and relation type ids to their nodes.
+// Each kind is fetched on first use and cached for the TTL. It is safe for concurrent use.
+type Resolver struct {
+	client *Client
+	ttl    time.Duration
+	now    func() time.Time
+	mu     sync.Mutex
+	cache  map[NodeKind]nodeCache
+}
+
+type nodeCache struct {
+	nodes   map[int64]NodeType
+	fetched time.Time
+}
+
+// NamedComponent is a view component with the names of its layer, domain and type.
+type NamedComponent struct {
+	ViewComponent
+	LayerName  string `json:"layerName"`
+	DomainName string `json:"domainName"`
+	TypeName   string `json:"typeName"`
+}
+
+// NamedSyncComponent is a script result component with the names of its layer and domain.
+type NamedSyncComponent struct {
+	SyncComponent
+	LayerName  string `json:"layerName"`
+	DomainName string `json:"domainName"`
+}
+
+// NamedRelation is a view relation with the name of its type.
+type NamedRelation struct {
+	ViewRelation
+	TypeName string `json:"typeName"`
+}
+
+// NewResolver creates a resolver that caches nodes for ttl, or DefaultResolverTTL when ttl is 0.
+func NewResolver(client *Client, ttl time.Duration) *Resolver {
+	if ttl <= 0 {
+		ttl = DefaultResolverTTL
+	}
+	return &Resolver{client: client, ttl: ttl, now: time.Now, cache: make(map[NodeKind]nodeCache)}
+}
+
+// Nodes returns all nodes of a kind by id, fetching them when the cache is empty or expired.
+// The map is shared with the cache and must not be modified.
+func (r *Resolver) Nodes(ctx context.Context, kind NodeKind) (map[int64]NodeType, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.cache[kind]; ok && r.now().Sub(c.fetched) < r.ttl {
+		return c.nodes, nil
+	}
+	return r.fetch(ctx, kind)
+}
+
+func (r *Resolver) fetch(ctx context.Context, kind NodeKind) (map[int64]NodeType, error) {
+	nodes, err := r.client.getNodesOfType(ctx, string(kind))
+	if err != nil {
+		return nil, err
+	}
+	r.cache[kind] = nodeCache{nodes: *nodes, fetched: r.now()}
+	return *nodes, nil
+}
+
+// Refresh fetches all kinds again, regardless of the TTL.
+func (r *Resolver) Refresh(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, kind := range nodeKinds {
+		if _, err := r.fetch(ctx, kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invalidate drops the cache, so every kind is fetched again on next use.
+func (r *Resolver) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.cache)
+}
+
+// Resolve returns the node of a kind with the given id.
+func (r *Resolver) Resolve(ctx context.Context, kind NodeKind, id int64) (NodeType, bool, error) {
+	nodes, err := r.Nodes(ctx, kind)
+	if err != nil {
+		return NodeType{}, false, err
+	}
+	n, ok := nodes[id]
+	return n, ok, nil
+}
+
+// Name returns the name of the node of a kind with the given id, or "" when there is none.
+func (r *Resolver) Name(ctx context.Context, kind NodeKind, id int64) (string, error) {
+	n, _, err := r.Resolve(ctx, kind, id)
+	return n.Name, err
+}
+
+// NameComponents adds layer, domain and component type names to view components.
+func (r *Resolver) NameComponents(ctx context.Context, components []ViewComponent) ([]NamedComponent, error) {
+	layers, err := r.Nodes(ctx, KindLayer)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := r.Nodes(ctx, KindDomain)
+	if err != nil {
+		return nil, err
+	}
+	types, err := r.Nodes(ctx, KindComponentType)
+	if err != nil {
+		return nil, err
+	}
+	named := make([]NamedComponent, 0, len(components))
+	for _, c := range components {
+		named = append(named, NamedComponent{
+			ViewComponent: c,
+			LayerName:     layers[int64(c.Layer)].Name,
+			DomainName:    domains[int64(c.Domain)].Name,
+			TypeName:      types[c.Type].Name,
+		})
+	}
+	return named, nil
+}
+
+// NameSyncComponents adds layer and domain names to topology script results.
+func (r *Resolver) NameSyncComponents(ctx context.Context, components []SyncComponent) ([]NamedSyncComponent, error) {
+	layers, err := r.Nodes(ctx, KindLayer)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := r.Nodes(ctx, KindDomain)
+	if err != nil {
+		return nil, err
+	}
+	named := make([]NamedSyncComponent, 0, len(components))
+	for _, c := range components {
+		named = append(named, NamedSyncComponent{
+			SyncComponent: c,
+			LayerName:     layers[int64(c.Layer)].Name,
+			DomainName:    domains[int64(c.Domain)].Name,
+		})
+	}
+	return named, nil
+}
+
+// NameRelations adds relation type names to view relations.
+func (r *Resolver) NameRelations(ctx context.Context, relations []ViewRelation) ([]NamedRelation, error) {
+	types, err := r.Nodes(ctx, KindRelationType)
+	if err != nil {
+		return nil, err
+	}
+	named := make([]NamedRelation, 0, len(relations))
+	for _, rel := range relations {
+		named = append(named, NamedRelation{ViewRelation: rel, TypeName: types[rel.Type].Name})
+	}
+	return named, nil
+}
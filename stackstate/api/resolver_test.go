@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nodeServer answers node requests with a single node of each kind and counts the requests.
+func nodeServer(t *testing.T, requests *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch strings.TrimPrefix(r.URL.Path, "/api/node/") {
+		case "Layer":
+			_, _ = w.Write([]byte(`[{"id": 100, "name": "Services", "_type": "Layer"}]`))
+		case "Domain":
+			_, _ = w.Write([]byte(`[{"id": 200, "name": "Shop", "_type": "Domain"}]`))
+		case "ComponentType":
+			_, _ = w.Write([]byte(`[{"id": 10, "name": "service", "_type": "ComponentType"}, {"id": 20, "name": "database", "_type": "ComponentType"}]`))
+		case "RelationType":
+			_, _ = w.Write([]byte(`[{"id": 30, "name": "calls", "_type": "RelationType"}]`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	var requests atomic.Int32
+	client, server := getClient(t, nodeServer(t, &requests))
+	defer server.Close()
+	ctx := context.Background()
+	r := NewResolver(client, time.Minute)
+
+	components, err := r.NameComponents(ctx, []ViewComponent{{ID: 1, Layer: 100, Domain: 200, Type: 10}, {ID: 2, Layer: 7, Type: 20}})
+	require.NoError(t, err)
+	assert.Equal(t, "Services", components[0].LayerName)
+	assert.Equal(t, "Shop", components[0].DomainName)
+	assert.Equal(t, "service", components[0].TypeName)
+	assert.Equal(t, int64(1), components[0].ID)
+	assert.Equal(t, "", components[1].LayerName)
+	assert.Equal(t, "database", components[1].TypeName)
+	assert.Equal(t, int32(3), requests.Load())
+
+	relations, err := r.NameRelations(ctx, []ViewRelation{{ID: 5, Type: 30}})
+	require.NoError(t, err)
+	assert.Equal(t, "calls", relations[0].TypeName)
+
+	synced, err := r.NameSyncComponents(ctx, []SyncComponent{{Id: 1, Layer: 100, Domain: 200}})
+	require.NoError(t, err)
+	assert.Equal(t, "Services", synced[0].LayerName)
+	assert.Equal(t, "Shop", synced[0].DomainName)
+
+	name, err := r.Name(ctx, KindComponentType, 20)
+	require.NoError(t, err)
+	assert.Equal(t, "database", name)
+	_, ok, err := r.Resolve(ctx, KindLayer, 999)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, int32(4), requests.Load(), "cached kinds are not fetched again")
+}
+
+func TestResolverTTLAndRefresh(t *testing.T) {
+	var requests atomic.Int32
+	client, server := getClient(t, nodeServer(t, &requests))
+	defer server.Close()
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	r := NewResolver(client, time.Minute)
+	r.now = func() time.Time { return now }
+
+	_, err := r.Nodes(ctx, KindLayer)
+	require.NoError(t, err)
+	now = now.Add(59 * time.Second)
+	_, err = r.Nodes(ctx, KindLayer)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+
+	now = now.Add(time.Second)
+	_, err = r.Nodes(ctx, KindLayer)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load(), "expired kinds are fetched again")
+
+	require.NoError(t, r.Refresh(ctx))
+	assert.Equal(t, int32(6), requests.Load())
+
+	r.Invalidate()
+	_, err = r.Nodes(ctx, KindLayer)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), requests.Load())
+}
+
+func TestResolverError(t *testing.T) {
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	defer server.Close()
+	_, err := NewResolver(client, 0).NameComponents(context.Background(), []ViewComponent{{ID: 1}})
+	assert.ErrorIs(t, err, ErrForbidden)
+}
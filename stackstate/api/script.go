@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+)
+
+var (
+	identifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// atValue matches epoch milliseconds or a time relative to now, e.g. -1h.
+	atValue = regexp.MustCompile(`^(\d+|-\d+(ms|s|m|h|d|w))$`)
+)
+
+// Script composes a Groovy script as a chain of method calls. Arguments are written as escaped
+// literals, so user supplied values can never break out of the script.
+// Its methods return modified copies; the first invalid call is reported by Build.
+//
+//	body, err := api.TopologyScript(query).At("-1h").FullComponents().Build()
+type Script struct {
+	calls []string
+	err   error
+}
+
+// NewScript starts a script at a Groovy object such as Topology or TopologyStream.
+func NewScript(object string) Script {
+	var s Script
+	if !identifier.MatchString(object) {
+		s.err = fmt.Errorf("invalid script object %q", object)
+	}
+	s.calls = []string{object}
+	return s
+}
+
+// TopologyScript starts a Topology.query for an STQL query.
+func TopologyScript(query string) Script {
+	return NewScript("Topology").Call("query", STQL(query))
+}
+
+// TopologyStreamScript starts a TopologyStream.query for an STQL query.
+func TopologyStreamScript(query string) Script {
+	return NewScript("TopologyStream").Call("query", STQL(query))
+}
+
+// STQL normalises single quoted string literals in an STQL query to double quoted ones, so queries
+// may be written as type = 'service'. Double quoted literals are left as they are.
+type STQL string
+
+// Call appends a method call. Arguments may be strings, STQL, booleans or numbers.
+func (s Script) Call(method string, args ...any) Script {
+	if s.err != nil {
+		return s
+	}
+	if !identifier.MatchString(method) {
+		s.err = fmt.Errorf("invalid script method %q", method)
+		return s
+	}
+	literals := make([]string, 0, len(args))
+	for _, a := range args {
+		l, err := groovyLiteral(a)
+		if err != nil {
+			s.err = fmt.Errorf("argument of %s: %w", method, err)
+			return s
+		}
+		literals = append(literals, l)
+	}
+	s.calls = append(append([]string{}, s.calls...), method+"("+strings.Join(literals, ", ")+")")
+	return s
+}
+
+// At evaluates the query at a point in time, given as epoch milliseconds or relative to now,
+// e.g. -1h. An empty value leaves the script unchanged.
+func (s Script) At(at string) Script {
+	if at == "" || s.err != nil {
+		return s
+	}
+	if !atValue.MatchString(at) {
+		s.err = fmt.Errorf("%w %q: expected epoch milliseconds or a relative time such as -1h", ErrInvalidAt, at)
+		return s
+	}
+	return s.Call("at", at)
+}
+
+// AtTime evaluates the query at t. The zero time leaves the script unchanged.
+func (s Script) AtTime(t time.Time) Script {
+	return s.At(FormatAt(t))
+}
+
+// FormatAt formats t as the epoch milliseconds the at parameter of topology queries expects,
+// or "" for the zero time.
+func FormatAt(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func (s Script) Components() Script {
+	return s.Call("components")
+}
+
+func (s Script) FullComponents() Script {
+	return s.Call("fullComponents")
+}
+
+func (s Script) WithSynchronizationData() Script {
+	return s.Call("withSynchronizationData")
+}
+
+// Build returns the script body or the first error.
+func (s Script) Build() (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	if len(s.calls) == 0 {
+		return "", fmt.Errorf("empty script")
+	}
+	return strings.Join(s.calls, "."), nil
+}
+
+func groovyLiteral(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return GroovyString(v), nil
+	case STQL:
+		return GroovyString(doubleQuoteLiterals(string(v))), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10) + "L", nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64) + "d", nil
+	}
+	return "", fmt.Errorf("unsupported argument type %T", v)
+}
+
+// GroovyString quotes s as a single quoted Groovy string, which does not interpolate ${...}.
+func GroovyString(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x10000 && !unicode.IsPrint(r) {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// doubleQuoteLiterals rewrites 'literal' as "literal", escaping double quotes inside it.
+func doubleQuoteLiterals(query string) string {
+	var b strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range query {
+		switch {
+		case escaped:
+			escaped = false
+			if quote == '\'' && r == '\'' {
+				// \' needs no escape once the literal is double quoted
+				b.WriteRune(r)
+				continue
+			}
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case quote != 0 && r == '\\':
+			escaped = true
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+			b.WriteByte('"')
+		case quote == r:
+			quote = 0
+			b.WriteByte('"')
+		case quote == '\'' && r == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaped {
+		b.WriteByte('\\')
+	}
+	return b.String()
+}
+
+type scriptOptions struct {
+	timeoutMs  int64
+	idempotent bool
+}
+
+// ScriptOption configures ExecuteScript.
+type ScriptOption func(*scriptOptions)
+
+// ScriptTimeoutMs limits how long the server runs the script.
+func ScriptTimeoutMs(ms int64) ScriptOption {
+	return func(o *scriptOptions) {
+		o.timeoutMs = ms
+	}
+}
+
+// ScriptIdempotent marks a script that only reads data, so it is retried like other idempotent
+// requests when the client has a retry policy.
+func ScriptIdempotent() ScriptOption {
+	return func(o *scriptOptions) {
+		o.idempotent = true
+	}
+}
+
+// ExecuteScript runs a Groovy script and decodes its result into T, which may be json.RawMessage.
+// Errors reported by the server are returned as *APIError.
+//
+//	body, _ := api.NewScript("Component").Call("withId", int64(id)).Call("get").Build()
+//	component, err := api.ExecuteScript[map[string]any](ctx, client, body)
+func ExecuteScript[T any](ctx context.Context, c *Client, script string, opts ...ScriptOption) (T, error) {
+	var result T
+	o := scriptOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	req := scriptRequest{ReqType: GroovyScript, Body: script, TimeoutMs: o.timeoutMs}
+	slog.Debug("request", "body", req.Body)
+	if o.idempotent {
+		ctx = sts.Idempotent(ctx)
+	}
+	var res scriptResponse
+	err := c.apiRequests("script").
+		BodyJSON(&req).
+		ToJSON(&res).
+		Fetch(ctx)
+	if err != nil {
+		return result, err
+	}
+	if len(res.Errors) > 0 {
+		return result, newAPIError("script", http.StatusOK, res.Errors)
+	}
+	if len(res.Result) == 0 {
+		return result, nil
+	}
+	if err := json.Unmarshal(res.Result, &result); err != nil {
+		return result, fmt.Errorf("decode script result: %w", err)
+	}
+	return result, nil
+}
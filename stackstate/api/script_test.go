@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroovyString(t *testing.T) {
+	assert.Equal(t, `'plain'`, GroovyString("plain"))
+	assert.Equal(t, `'it\'s a "quote" \\ ${x}\n'`, GroovyString("it's a \"quote\" \\ ${x}\n"))
+	assert.Equal(t, `'\u0000'`, GroovyString("\x00"))
+}
+
+func TestScriptBuilder(t *testing.T) {
+	body, err := TopologyScript("type = 'service' and label in ('namespace:kube-system')").At("-1h").FullComponents().Build()
+	require.NoError(t, err)
+	assert.Equal(t, `Topology.query('type = "service" and label in ("namespace:kube-system")').at('-1h').fullComponents()`, body)
+
+	body, err = TopologyStreamScript(`name = "it's"`).At("1730470751000").WithSynchronizationData().Build()
+	require.NoError(t, err)
+	assert.Equal(t, `TopologyStream.query('name = "it\'s"').at('1730470751000').withSynchronizationData()`, body)
+
+	body, err = NewScript("Topology").Call("query", "x").Call("limit", 5).Build()
+	require.NoError(t, err)
+	assert.Equal(t, `Topology.query('x').limit(5)`, body)
+}
+
+func TestScriptInjection(t *testing.T) {
+	body, err := TopologyScript(`x') ; System.exit(0) ; ('`).Components().Build()
+	require.NoError(t, err)
+	assert.Equal(t, `Topology.query('x") ; System.exit(0) ; ("').components()`, body)
+
+	body, err = TopologyScript(`name = 'a"b'`).Build()
+	require.NoError(t, err)
+	assert.Equal(t, `Topology.query('name = "a\\"b"')`, body)
+
+	_, err = TopologyScript("x").At("0') ; System.exit(0) ; ('").Build()
+	assert.ErrorIs(t, err, ErrInvalidAt)
+	_, err = NewScript("Topology").Call("query(); System.exit").Build()
+	assert.Error(t, err)
+	_, err = NewScript("Topology").Call("query", struct{}{}).Build()
+	assert.Error(t, err)
+}
+
+func TestDoubleQuoteLiterals(t *testing.T) {
+	tests := map[string]string{
+		`type = 'pod'`:             `type = "pod"`,
+		`type = "pod"`:             `type = "pod"`,
+		`name = "it's"`:            `name = "it's"`,
+		`name = 'it\'s'`:           `name = "it's"`,
+		`name = 'say "hi"'`:        `name = "say \"hi\""`,
+		`name = "a\"b" or x = 'y'`: `name = "a\"b" or x = "y"`,
+	}
+	for in, expected := range tests {
+		assert.Equal(t, expected, doubleQuoteLiterals(in), in)
+	}
+}
+
+func TestTopologyQueryScript(t *testing.T) {
+	var body string
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req scriptRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		body = req.Body
+		_, _ = w.Write([]byte(`{"result": []}`))
+	})
+	defer server.Close()
+
+	res, err := client.TopologyQuery(`name = "it's" and type = 'pod'`, "-5m", true)
+	require.NoError(t, err)
+	assert.True(t, res.Success)
+	assert.Equal(t, `Topology.query('name = "it\'s" and type = "pod"').at('-5m').fullComponents()`, body)
+
+	_, err = client.TopologyStreamQuery("type = 'pod'", "yesterday", false)
+	assert.ErrorIs(t, err, ErrInvalidAt)
+}
+
+func TestExecuteScript(t *testing.T) {
+	var req scriptRequest
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/script", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		switch req.Body {
+		case "fail":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors": [{"message": "The supplied authentication is not authorized to access this resource", "errorCode": 403}]}`))
+		case "reported":
+			_, _ = w.Write([]byte(`{"errors": [{"message": "No such property: foo", "errorCode": 500}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"result": {"name": "checkout", "count": 3}}`))
+		}
+	})
+	defer server.Close()
+	ctx := context.Background()
+
+	type result struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	res, err := ExecuteScript[result](ctx, client, "Component.withId(1L).get()", ScriptTimeoutMs(5000))
+	require.NoError(t, err)
+	assert.Equal(t, result{Name: "checkout", Count: 3}, res)
+	assert.Equal(t, scriptRequest{ReqType: GroovyScript, Body: "Component.withId(1L).get()", TimeoutMs: 5000}, req)
+
+	raw, err := ExecuteScript[json.RawMessage](ctx, client, "x")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "checkout", "count": 3}`, string(raw))
+
+	_, err = ExecuteScript[[]string](ctx, client, "x")
+	assert.ErrorContains(t, err, "decode script result")
+
+	_, err = ExecuteScript[json.RawMessage](ctx, client, "fail")
+	assert.ErrorIs(t, err, ErrForbiddenScript)
+
+	_, err = ExecuteScript[json.RawMessage](ctx, client, "reported")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 500, apiErr.ErrorCode)
+	assert.Equal(t, "No such property: foo", apiErr.Errors[0].Message)
+}
@@ -0,0 +1,84 @@
+package api
+
+import "time"
+
+// SnapshotOption configures a ViewSnapshotRequest.
+type SnapshotOption func(*ViewSnapshotRequest)
+
+type GroupBy int
+
+const (
+	GroupByLayer GroupBy = iota
+	GroupByDomain
+	GroupByRelation
+)
+
+// ShowCause selects which root causes of unhealthy components are added to the result.
+type ShowCause string
+
+const (
+	ShowCauseNone      ShowCause = "NONE"       // no root causes
+	ShowCauseRootCause ShowCause = "ROOT_CAUSE" // only the probable root cause
+	ShowCauseAll       ShowCause = "ALL"        // the full root cause tree
+)
+
+// WithFullComponent returns components with all their data.
+func WithFullComponent() SnapshotOption {
+	return func(r *ViewSnapshotRequest) {
+		r.Metadata.ShowFullComponent = true
+	}
+}
+
+// WithGrouping groups at least minGroupSize similar components, split by the given criteria.
+func WithGrouping(minGroupSize int, by ...GroupBy) SnapshotOption {
+	return func(r *ViewSnapshotRequest) {
+		r.Metadata.GroupingEnabled = true
+		r.Metadata.MinGroupSize = minGroupSize
+		for _, b := range by {
+			switch b {
+			case GroupByLayer:
+				r.Metadata.GroupedByLayer = true
+			case GroupByDomain:
+				r.Metadata.GroupedByDomain = true
+			case GroupByRelation:
+				r.Metadata.GroupedByRelation = true
+			}
+		}
+	}
+}
+
+// WithIndirectRelations adds relations between result components that pass through components
+// outside the result.
+func WithIndirectRelations() SnapshotOption {
+	return func(r *ViewSnapshotRequest) {
+		r.Metadata.ShowIndirectRelations = true
+	}
+}
+
+// WithNeighbours adds the components directly related to the result.
+func WithNeighbours() SnapshotOption {
+	return func(r *ViewSnapshotRequest) {
+		r.Metadata.NeighboringComponents = true
+	}
+}
+
+// WithConnectedComponents adds all components connected to the result.
+func WithConnectedComponents() SnapshotOption {
+	return func(r *ViewSnapshotRequest) {
+		r.Metadata.ConnectedComponents = true
+	}
+}
+
+// WithQueryTime evaluates the query at t, or now for the zero time.
+func WithQueryTime(t time.Time) SnapshotOption {
+	return func(r *ViewSnapshotRequest) {
+		r.Metadata.SetQueryTime(t)
+	}
+}
+
+// WithShowCause adds the root causes of unhealthy components, ShowCauseNone by default.
+func WithShowCause(cause ShowCause) SnapshotOption {
+	return func(r *ViewSnapshotRequest) {
+		r.Metadata.ShowCause = string(cause)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// assertGolden compares JSON with testdata/api/snapshot/requests/<name>.json, or writes it with -update.
+func assertGolden(t *testing.T, name string, actual []byte) {
+	var indented bytes.Buffer
+	require.NoError(t, json.Indent(&indented, actual, "", "  "))
+	indented.WriteByte('\n')
+	path := "../../testdata/api/snapshot/requests/" + name + ".json"
+	if *update {
+		require.NoError(t, os.WriteFile(path, indented.Bytes(), 0o644))
+	}
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), indented.String())
+}
+
+func TestViewSnapshotRequestGolden(t *testing.T) {
+	incident := time.Date(2024, 11, 1, 3, 12, 0, 0, time.UTC)
+	tests := map[string][]SnapshotOption{
+		"default":    nil,
+		"full":       {WithFullComponent(), WithIndirectRelations(), WithShowCause(ShowCauseNone)},
+		"grouping":   {WithGrouping(3, GroupByLayer, GroupByDomain, GroupByRelation)},
+		"neighbour":  {WithNeighbours(), WithConnectedComponents(), WithQueryTime(incident)},
+		"root-cause": {WithShowCause(ShowCauseRootCause)},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, err := json.Marshal(NewViewSnapshotRequest("type = 'pod'", opts...))
+			require.NoError(t, err)
+			assertGolden(t, name, b)
+		})
+	}
+}
+
+func TestSnapShotTopologyQueryOptions(t *testing.T) {
+	var body []byte
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		loadRespFile(w, "api/snapshot/response.json")
+	})
+	defer server.Close()
+
+	_, err := client.SnapShotTopologyQuery("type = 'pod'", WithGrouping(3, GroupByLayer, GroupByDomain, GroupByRelation))
+	require.NoError(t, err)
+	assertGolden(t, "grouping", body)
+
+	incident := time.Date(2024, 11, 1, 3, 12, 0, 0, time.UTC)
+	_, err = client.ViewSnapshotAt(context.Background(), "type = 'pod'", incident, WithNeighbours(), WithConnectedComponents())
+	require.NoError(t, err)
+	assertGolden(t, "neighbour", body)
+}
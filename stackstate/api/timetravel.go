@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TopologyQueryAt is TopologyQueryCtx evaluated at t, or now for the zero time.
+func (c *Client) TopologyQueryAt(ctx context.Context, query string, t time.Time, fullLoad bool) (*TopoQueryResponse, error) {
+	return c.TopologyQueryCtx(ctx, query, FormatAt(t), fullLoad)
+}
+
+// TopologyStreamQueryAt is TopologyStreamQueryCtx evaluated at t, or now for the zero time.
+func (c *Client) TopologyStreamQueryAt(ctx context.Context, query string, t time.Time, withSyncData bool) (*TopoQueryResponse, error) {
+	return c.TopologyStreamQueryCtx(ctx, query, FormatAt(t), withSyncData)
+}
+
+// SnapShotTopologyQueryAt is SnapShotTopologyQueryCtx evaluated at t, or now for the zero time.
+func (c *Client) SnapShotTopologyQueryAt(ctx context.Context, query string, t time.Time, opts ...SnapshotOption) ([]ViewComponent, error) {
+	res, err := c.ViewSnapshotAt(ctx, query, t, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return res.Components, nil
+}
+
+// ViewSnapshotAt fetches the view snapshot of query at t, or now for the zero time.
+// Unlike ViewSnapshotCtx, a snapshot the server refused is returned as error. A non-zero t takes
+// precedence over a WithQueryTime option.
+func (c *Client) ViewSnapshotAt(ctx context.Context, query string, t time.Time, opts ...SnapshotOption) (*ViewSnapshotResponse, error) {
+	req := NewViewSnapshotRequest(query, opts...)
+	if !t.IsZero() {
+		req.Metadata.SetQueryTime(t)
+	}
+	res, err := c.ViewSnapshotCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Success {
+		return nil, res.Err()
+	}
+	return res, nil
+}
+
+// ViewSnapshotsAt fetches the view snapshot of the same query at two points in time concurrently,
+// e.g. before and during an incident.
+func (c *Client) ViewSnapshotsAt(ctx context.Context, query string, before, after time.Time, opts ...SnapshotOption) (*ViewSnapshotResponse, *ViewSnapshotResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var res [2]*ViewSnapshotResponse
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, t := range []time.Time{before, after} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshot, err := c.ViewSnapshotAt(ctx, query, t, opts...)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			res[i] = snapshot
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	return res[0], res[1], nil
+}
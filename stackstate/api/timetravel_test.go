@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatAt(t *testing.T) {
+	incident := time.Date(2024, 11, 1, 3, 12, 0, 0, time.UTC)
+	assert.Equal(t, "1730430720000", FormatAt(incident))
+	assert.Equal(t, "1730430720000", FormatAt(incident.In(time.FixedZone("CET", 3600))))
+	assert.Equal(t, "", FormatAt(time.Time{}))
+
+	body, err := TopologyScript("type = 'pod'").AtTime(incident).Components().Build()
+	require.NoError(t, err)
+	assert.Equal(t, `Topology.query('type = "pod"').at('1730430720000').components()`, body)
+
+	var m ViewSnapshotMetadata
+	m.SetQueryTime(incident)
+	assert.Equal(t, int64(1730430720000), m.QueryTime)
+	assert.True(t, incident.Equal(m.Time()))
+	m.SetQueryTime(time.Time{})
+	assert.Zero(t, m.QueryTime)
+	assert.True(t, m.Time().IsZero())
+}
+
+func TestTopologyQueryAt(t *testing.T) {
+	var body string
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req scriptRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		body = req.Body
+		_, _ = w.Write([]byte(`{"result": []}`))
+	})
+	defer server.Close()
+	ctx := context.Background()
+	at := time.UnixMilli(1730430720000)
+
+	_, err := client.TopologyQueryAt(ctx, "type = 'pod'", at, false)
+	require.NoError(t, err)
+	assert.Equal(t, `Topology.query('type = "pod"').at('1730430720000').components()`, body)
+
+	_, err = client.TopologyStreamQueryAt(ctx, "type = 'pod'", time.Time{}, true)
+	require.NoError(t, err)
+	assert.Equal(t, `TopologyStream.query('type = "pod"').withSynchronizationData()`, body)
+}
+
+func TestViewSnapshotsAt(t *testing.T) {
+	before := time.UnixMilli(1730430000000)
+	after := time.UnixMilli(1730430720000)
+	var mu sync.Mutex
+	var queryTimes []int64
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req ViewSnapshotRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		queryTimes = append(queryTimes, req.Metadata.QueryTime)
+		mu.Unlock()
+		if req.Metadata.QueryTime == before.UnixMilli() {
+			loadRespFile(w, "api/snapshot/response.json")
+		} else {
+			loadRespFile(w, "api/snapshot/relations.json")
+		}
+	})
+	defer server.Close()
+
+	old, current, err := client.ViewSnapshotsAt(context.Background(), "type = 'pod'", before, after)
+	require.NoError(t, err)
+	assert.Equal(t, "k3k-vcluster-server-1", old.Components[0].Name)
+	assert.Equal(t, "frontend", current.Components[0].Name)
+	assert.ElementsMatch(t, []int64{before.UnixMilli(), after.UnixMilli()}, queryTimes)
+
+	components, err := client.SnapShotTopologyQueryAt(context.Background(), "type = 'pod'", before)
+	require.NoError(t, err)
+	assert.Len(t, components, 3)
+}
+
+func TestViewSnapshotAtKeepsQueryTimeOption(t *testing.T) {
+	var queryTime int64
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req ViewSnapshotRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		queryTime = req.Metadata.QueryTime
+		loadRespFile(w, "api/snapshot/response.json")
+	})
+	defer server.Close()
+	ctx := context.Background()
+	option := WithQueryTime(time.UnixMilli(1730430000000))
+
+	_, err := client.ViewSnapshotAt(ctx, "type = 'pod'", time.Time{}, option)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1730430000000), queryTime)
+
+	_, err = client.ViewSnapshotAt(ctx, "type = 'pod'", time.UnixMilli(1730430720000), option)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1730430720000), queryTime)
+}
+
+func TestViewSnapshotsAtFails(t *testing.T) {
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req ViewSnapshotRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Metadata.QueryTime == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors": [{"message": "query time is outside of retention", "errorCode": 400}]}`))
+			return
+		}
+		loadRespFile(w, "api/snapshot/response.json")
+	})
+	defer server.Close()
+
+	_, _, err := client.ViewSnapshotsAt(context.Background(), "type = 'pod'", time.UnixMilli(1), time.UnixMilli(2))
+	assert.ErrorContains(t, err, "outside of retention")
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"iter"
+)
+
+// DefaultTracePageSize is used by TraceRefs and Traces when the request does not set a page size.
+const DefaultTracePageSize = 100
+
+// TraceRefs walks every page of the trace query, starting at req.Page, and yields the matching references.
+// It works with both the current and the legacy trace api. Iteration stops at the first error,
+// which is yielded, or when ctx is cancelled.
+func (c *Client) TraceRefs(ctx context.Context, req *TraceQueryRequest) iter.Seq2[TraceRef, error] {
+	return func(yield func(TraceRef, error) bool) {
+		page := *req
+		if page.PageSize <= 0 {
+			page.PageSize = DefaultTracePageSize
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(TraceRef{}, err)
+				return
+			}
+			res, err := c.QueryTracesCtx(ctx, &page)
+			if err != nil {
+				yield(TraceRef{}, err)
+				return
+			}
+			for _, ref := range res.Traces {
+				if !yield(ref, nil) {
+					return
+				}
+			}
+			// the server may use a smaller page size than requested
+			if res.PageSize > 0 {
+				page.PageSize = res.PageSize
+			}
+			if len(res.Traces) == 0 || page.Page*page.PageSize+len(res.Traces) >= res.MatchesTotal {
+				return
+			}
+			page.Page++
+		}
+	}
+}
+
+// Traces walks the trace query like TraceRefs and fetches the full trace of every distinct trace id
+// with GetTrace. At most concurrency traces are fetched at the same time. Traces are yielded in the
+// order of the query results.
+func (c *Client) Traces(ctx context.Context, req *TraceQueryRequest, concurrency int) iter.Seq2[*Trace, error] {
+	return func(yield func(*Trace, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type result struct {
+			trace *Trace
+			err   error
+		}
+		concurrency = max(concurrency, 1)
+		pending := make(chan chan result, concurrency)
+		running := make(chan struct{}, concurrency)
+		go func() {
+			defer close(pending)
+			fetched := make(map[string]bool)
+			for ref, err := range c.TraceRefs(ctx, req) {
+				done := make(chan result, 1)
+				if err != nil {
+					done <- result{err: err}
+				} else if fetched[ref.TraceID] {
+					continue
+				} else {
+					fetched[ref.TraceID] = true
+					select {
+					case running <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+					go func(id string) {
+						defer func() { <-running }()
+						t, err := c.GetTraceCtx(ctx, id)
+						done <- result{trace: t, err: err}
+					}(ref.TraceID)
+				}
+				select {
+				case pending <- done:
+				case <-ctx.Done():
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		for done := range pending {
+			r := <-done
+			if !yield(r.trace, r.err) || r.err != nil {
+				return
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
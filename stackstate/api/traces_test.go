@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedTraceServer serves total trace references, two per page, on both the current and legacy
+// endpoints. It reports a 6.0 server, so the client detects the current api.
+func pagedTraceServer(t *testing.T, total int, inFlight, maxInFlight *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/server/info":
+			loadRespFile(w, "api/server/info.json")
+		case "/api/traces/query", "/api/traces/spans":
+			var q TraceQuery
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&q))
+			if r.URL.Path == "/api/traces/spans" {
+				assert.Equal(t, []string{"svc"}, q.Filter.ServiceName)
+			} else {
+				assert.Equal(t, []string{"svc"}, q.SpanFilter.ServiceName)
+			}
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			size, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+			var refs []TraceRef
+			for i := page * size; i < min((page+1)*size, total); i++ {
+				refs = append(refs, TraceRef{TraceID: fmt.Sprintf("t%d", i), SpanID: "s"})
+			}
+			key := "traces"
+			if r.URL.Path == "/api/traces/spans" {
+				key = "spans"
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{key: refs, "page": page, "pageSize": size, "matchesTotal": total})
+		default:
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			_ = json.NewEncoder(w).Encode(Trace{TraceID: r.URL.Path[len("/api/traces/"):]})
+		}
+	}
+}
+
+func traceRequest() *TraceQueryRequest {
+	return &TraceQueryRequest{
+		TraceQuery: TraceQuery{SpanFilter: SpanFilter{ServiceName: []string{"svc"}}},
+		Start:      time.Now().Add(-time.Hour),
+		End:        time.Now(),
+		PageSize:   2,
+	}
+}
+
+func TestTraceRefsWalksAllPages(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("legacy=%v", legacy), func(t *testing.T) {
+			var inFlight, maxInFlight atomic.Int32
+			conf := getConfig(t)
+			conf.LegacyApi = legacy
+			var paths []string
+			handler := pagedTraceServer(t, 5, &inFlight, &maxInFlight)
+			server := getMockServer(conf, func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				handler(w, r)
+			})
+			defer server.Close()
+			client := NewClient(conf)
+
+			var ids []string
+			for ref, err := range client.TraceRefs(context.Background(), traceRequest()) {
+				require.NoError(t, err)
+				ids = append(ids, ref.TraceID)
+			}
+			assert.Equal(t, []string{"t0", "t1", "t2", "t3", "t4"}, ids)
+			used, unused := "/api/traces/query", "/api/traces/spans"
+			if legacy {
+				used, unused = unused, used
+			}
+			assert.Contains(t, paths, used)
+			assert.NotContains(t, paths, unused)
+		})
+	}
+}
+
+func TestTraceRefsFollowsServerPageSize(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	handler := pagedTraceServer(t, 50, &inFlight, &maxInFlight)
+	client, server := getClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// the server returns at most 10 references per page
+		q := r.URL.Query()
+		if size, _ := strconv.Atoi(q.Get("pageSize")); size > 10 {
+			q.Set("pageSize", "10")
+			r.URL.RawQuery = q.Encode()
+		}
+		handler(w, r)
+	})
+	defer server.Close()
+	req := traceRequest()
+	req.PageSize = 100
+
+	var ids []string
+	for ref, err := range client.TraceRefs(context.Background(), req) {
+		require.NoError(t, err)
+		ids = append(ids, ref.TraceID)
+	}
+	require.Len(t, ids, 50)
+	assert.Equal(t, "t49", ids[49])
+}
+
+func TestTracesFetchesBodiesInOrder(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	client, server := getClient(t, pagedTraceServer(t, 9, &inFlight, &maxInFlight))
+	defer server.Close()
+
+	var ids []string
+	for trace, err := range client.Traces(context.Background(), traceRequest(), 3) {
+		require.NoError(t, err)
+		ids = append(ids, trace.TraceID)
+	}
+	assert.Equal(t, []string{"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7", "t8"}, ids)
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(3))
+}
+
+func TestTracesStopsOnCancel(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	client, server := getClient(t, pagedTraceServer(t, 100, &inFlight, &maxInFlight))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count := 0
+	var lastErr error
+	for _, err := range client.Traces(ctx, traceRequest(), 2) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		count++
+		if count == 3 {
+			cancel()
+		}
+	}
+	assert.ErrorIs(t, lastErr, context.Canceled)
+	assert.Less(t, count, 100)
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -10,14 +12,8 @@ import (
 // StackState Server Api DTOs
 
 type ServerInfo struct {
-	Version struct {
-		Major  int    `json:"major"`
-		Patch  int    `json:"patch"`
-		Diff   string `json:"diff"`
-		Commit string `json:"commit"`
-		IsDev  bool   `json:"isDev"`
-	} `json:"version"`
-	DeploymentMode string `json:"deploymentMode"`
+	Version        Version `json:"version"`
+	DeploymentMode string  `json:"deploymentMode"`
 }
 
 type SyncComponent struct {
@@ -75,9 +71,13 @@ func (t *Label) UnmarshalJSON(data []byte) error {
 }
 
 type MetricQueryResponse struct {
-	Status string      `json:"status"`
-	Errors []*ErrorMsg `json:"errors"`
-	Data   MetricData  `json:"data"`
+	Status    string      `json:"status"`
+	Errors    []*ErrorMsg `json:"errors"`
+	ErrorType string      `json:"errorType"`
+	Error     string      `json:"error"`
+	Warnings  []string    `json:"warnings"`
+	Infos     []string    `json:"infos"`
+	Data      MetricData  `json:"data"`
 }
 
 type MetricData struct {
@@ -85,91 +85,239 @@ type MetricData struct {
 	Result     []MetricResult `json:"result"`
 }
 
+type metricSeries struct {
+	Metric     map[string]string `json:"metric"`
+	Value      json.RawMessage   `json:"value"`
+	Values     []json.RawMessage `json:"values"`
+	Histogram  json.RawMessage   `json:"histogram"`
+	Histograms []json.RawMessage `json:"histograms"`
+}
+
+// UnmarshalJSON decodes the Prometheus result formats: vector, matrix, scalar and string.
+// Malformed input results in an error, never a panic.
 func (m *MetricData) UnmarshalJSON(data []byte) error {
-	mapFromArray := func(m *MetricPoint, data []any) error {
-		// Assign array values to the struct fields
-		var err error
-		m.Timestamp = int64(data[0].(float64))
-		m.Value, err = strconv.ParseFloat(strings.TrimSpace(data[1].(string)), 64)
-		if err != nil {
-			return err
-		}
-		return nil
+	var raw struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
 	}
-
-	var dataMap map[string]any
-	if err := json.Unmarshal(data, &dataMap); err != nil {
-		return err
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("metric data: %w", err)
+	}
+	m.ResultType = raw.ResultType
+	m.Result = []MetricResult{}
+	if len(raw.Result) == 0 || string(raw.Result) == "null" {
+		return nil
 	}
-	m.ResultType = dataMap["resultType"].(string)
 
-	resultList := dataMap["result"].([]any)
-	if m.ResultType == "scalar" || m.ResultType == "string" {
-		mr := MetricResult{
-			Labels: make(map[string]string),
-			Points: make([]MetricPoint, 0, 1),
+	switch m.ResultType {
+	case "scalar", "string":
+		var sample []json.RawMessage
+		if err := json.Unmarshal(raw.Result, &sample); err != nil {
+			return fmt.Errorf("metric data: %s result: %w", m.ResultType, err)
+		}
+		if len(sample) == 0 {
+			return nil
 		}
-		mr.Points = append(mr.Points, MetricPoint{})
-		err := mapFromArray(&mr.Points[0], resultList)
+		p, err := decodeSample(sample)
 		if err != nil {
-			return err
+			return fmt.Errorf("metric data: %s result: %w", m.ResultType, err)
 		}
-		m.Result = []MetricResult{mr}
+		m.Result = append(m.Result, MetricResult{Labels: map[string]string{}, Points: []MetricPoint{p}})
 		return nil
+	case "vector", "matrix":
+	default:
+		return fmt.Errorf("metric data: unknown result type %q", m.ResultType)
 	}
 
-	valueKey := "value"
-	if m.ResultType == "matrix" {
-		valueKey = "values"
+	var series []metricSeries
+	if err := json.Unmarshal(raw.Result, &series); err != nil {
+		return fmt.Errorf("metric data: %s result: %w", m.ResultType, err)
 	}
-	m.Result = make([]MetricResult, 0, len(resultList))
-	for _, item := range resultList {
-		resultMap := item.(map[string]any)
-		labels := resultMap["metric"].(map[string]any)
-		mr := MetricResult{
-			Labels: make(map[string]string, len(labels)),
-			Points: make([]MetricPoint, 0, len(resultMap[valueKey].([]any))),
+	for i, s := range series {
+		mr := MetricResult{Labels: s.Metric, Points: make([]MetricPoint, 0, max(len(s.Values)+len(s.Histograms), 1))}
+		if mr.Labels == nil {
+			mr.Labels = map[string]string{}
 		}
-
-		for k, v := range labels {
-			mr.Labels[k] = v.(string)
+		samples := s.Values
+		histograms := s.Histograms
+		if m.ResultType == "vector" {
+			samples = nonEmpty(s.Value)
+			histograms = nonEmpty(s.Histogram)
 		}
-
-		if m.ResultType == "matrix" {
-			for x, point := range resultMap["values"].([]any) {
-				mr.Points = append(mr.Points, MetricPoint{})
-				err := mapFromArray(&mr.Points[x], point.([]any))
-				if err != nil {
-					return err
-				}
+		for _, v := range samples {
+			p, err := decodePoint(v)
+			if err != nil {
+				return fmt.Errorf("metric data: series %d: %w", i, err)
 			}
-		} else {
-			mr.Points = append(mr.Points, MetricPoint{})
-			err := mapFromArray(&mr.Points[0], resultMap["value"].([]any))
+			mr.Points = append(mr.Points, p)
+		}
+		for _, h := range histograms {
+			p, err := decodeHistogramPoint(h)
 			if err != nil {
-				return err
+				return fmt.Errorf("metric data: series %d: %w", i, err)
 			}
+			mr.Points = append(mr.Points, p)
 		}
 		m.Result = append(m.Result, mr)
 	}
-
 	return nil
 }
 
+func nonEmpty(raw json.RawMessage) []json.RawMessage {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return []json.RawMessage{raw}
+}
+
+// decodePoint decodes a [<timestamp>, "<value>"] pair.
+func decodePoint(raw json.RawMessage) (MetricPoint, error) {
+	var sample []json.RawMessage
+	if err := json.Unmarshal(raw, &sample); err != nil {
+		return MetricPoint{}, fmt.Errorf("sample: %w", err)
+	}
+	return decodeSample(sample)
+}
+
+func decodeSample(sample []json.RawMessage) (MetricPoint, error) {
+	var p MetricPoint
+	if len(sample) != 2 {
+		return p, fmt.Errorf("sample must have a timestamp and a value, got %d elements", len(sample))
+	}
+	ts, err := decodeTimestamp(sample[0])
+	if err != nil {
+		return p, err
+	}
+	p.Timestamp = ts
+	p.Value, err = decodeFloat(sample[1])
+	if err != nil {
+		return p, fmt.Errorf("sample value: %w", err)
+	}
+	return p, nil
+}
+
+// decodeHistogramPoint decodes a [<timestamp>, {<histogram>}] pair of a native histogram.
+func decodeHistogramPoint(raw json.RawMessage) (MetricPoint, error) {
+	var p MetricPoint
+	var sample []json.RawMessage
+	if err := json.Unmarshal(raw, &sample); err != nil {
+		return p, fmt.Errorf("histogram: %w", err)
+	}
+	if len(sample) != 2 {
+		return p, fmt.Errorf("histogram must have a timestamp and a value, got %d elements", len(sample))
+	}
+	ts, err := decodeTimestamp(sample[0])
+	if err != nil {
+		return p, err
+	}
+	var h struct {
+		Count   json.RawMessage     `json:"count"`
+		Sum     json.RawMessage     `json:"sum"`
+		Buckets [][]json.RawMessage `json:"buckets"`
+	}
+	if err := json.Unmarshal(sample[1], &h); err != nil {
+		return p, fmt.Errorf("histogram: %w", err)
+	}
+	hist := &Histogram{Buckets: make([]HistogramBucket, 0, len(h.Buckets))}
+	if hist.Count, err = decodeFloat(h.Count); err != nil {
+		return p, fmt.Errorf("histogram count: %w", err)
+	}
+	if hist.Sum, err = decodeFloat(h.Sum); err != nil {
+		return p, fmt.Errorf("histogram sum: %w", err)
+	}
+	for _, b := range h.Buckets {
+		if len(b) != 4 {
+			return p, fmt.Errorf("histogram bucket must have 4 elements, got %d", len(b))
+		}
+		var bucket HistogramBucket
+		if err := json.Unmarshal(b[0], &bucket.Boundaries); err != nil {
+			return p, fmt.Errorf("histogram bucket boundaries: %w", err)
+		}
+		for i, f := range []*float64{&bucket.Lower, &bucket.Upper, &bucket.Count} {
+			if *f, err = decodeFloat(b[i+1]); err != nil {
+				return p, fmt.Errorf("histogram bucket: %w", err)
+			}
+		}
+		hist.Buckets = append(hist.Buckets, bucket)
+	}
+	p.Timestamp = ts
+	p.Value = hist.Sum
+	p.Histogram = hist
+	return p, nil
+}
+
+func decodeTimestamp(raw json.RawMessage) (int64, error) {
+	var ts float64
+	if err := json.Unmarshal(raw, &ts); err != nil {
+		return 0, fmt.Errorf("sample timestamp: %w", err)
+	}
+	if math.IsNaN(ts) || math.IsInf(ts, 0) {
+		return 0, fmt.Errorf("sample timestamp %v is not finite", ts)
+	}
+	return int64(ts), nil
+}
+
+// decodeFloat decodes a float that Prometheus encodes as a string, including "NaN", "+Inf" and "-Inf".
+func decodeFloat(raw json.RawMessage) (float64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		var f float64
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return 0, fmt.Errorf("expected a number as string, got %s", raw)
+		}
+		return f, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return f, nil
+}
+
 type MetricResult struct {
 	Labels map[string]string `json:"metric"`
 	Points []MetricPoint     `json:"values"`
 }
 
+// MetricPoint is a sample. For native histograms Value holds the sum of the observations.
 type MetricPoint struct {
-	Timestamp int64   `json:"timestamp"`
-	Value     float64 `json:"value"`
+	Timestamp int64      `json:"timestamp"`
+	Value     float64    `json:"value"`
+	Histogram *Histogram `json:"histogram,omitempty"`
+}
+
+// Histogram is a native histogram sample.
+type Histogram struct {
+	Count   float64           `json:"count"`
+	Sum     float64           `json:"sum"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket is a bucket of a native histogram. Boundaries tells which bounds are inclusive:
+// 0 upper only, 1 lower only, 2 neither, 3 both.
+type HistogramBucket struct {
+	Boundaries int     `json:"boundaries"`
+	Lower      float64 `json:"lower"`
+	Upper      float64 `json:"upper"`
+	Count      float64 `json:"count"`
 }
 
 type TopoQueryResponse struct {
 	Success bool            `json:"success"`
 	Errors  []*ErrorMsg     `json:"errors"`
 	Data    []SyncComponent `json:"data"`
+	err     *APIError
+}
+
+// Err returns the failure as an *APIError, or nil when the query succeeded.
+func (r *TopoQueryResponse) Err() error {
+	if r.Success {
+		return nil
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return newAPIError("script", 0, r.Errors)
 }
 
 type ErrorResp struct {
@@ -186,8 +334,14 @@ type ErrorMsg struct {
 }
 
 type scriptRequest struct {
-	ReqType string `json:"_type"`
-	Body    string `json:"body"`
+	ReqType   string `json:"_type"`
+	Body      string `json:"body"`
+	TimeoutMs int64  `json:"timeoutMs,omitempty"`
+}
+
+type scriptResponse struct {
+	Result json.RawMessage `json:"result"`
+	Errors []*ErrorMsg     `json:"errors"`
 }
 
 type querySnapshotResult struct {
@@ -195,36 +349,110 @@ type querySnapshotResult struct {
 }
 
 type ViewSnapshotResponse struct {
-	Success    bool `json:"success"`
-	Components []ViewComponent
-	Errors     []*ErrorMsg `json:"errors"`
+	Success           bool `json:"success"`
+	Components        []ViewComponent
+	Relations         []ViewRelation         `json:"relations"`
+	IndirectRelations []ViewIndirectRelation `json:"indirectRelations"`
+	Groups            []ViewComponentGroup   `json:"groups"`
+	GroupRelations    []ViewGroupRelation    `json:"groupRelations"`
+	Errors            []*ErrorMsg            `json:"errors"`
+	err               *APIError
+}
+
+// Err returns the failure as an *APIError, or nil when the query succeeded.
+func (r *ViewSnapshotResponse) Err() error {
+	if r.Success {
+		return nil
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return newAPIError("snapshot", 0, r.Errors)
 }
 
 type ViewComponent struct {
-	ID                  int64   `json:"id"`
-	Name                string  `json:"name"`
-	Description         string  `json:"description"`
-	LastUpdateTimestamp int64   `json:"lastUpdateTimestamp"`
-	Type                int64   `json:"type"`
-	Layer               int     `json:"layer"`
-	Domain              int     `json:"domain"`
-	Environments        []int64 `json:"environments"`
-	State               struct {
-		ID                    int64  `json:"id"`
-		LastUpdateTimestamp   int64  `json:"lastUpdateTimestamp"`
-		HealthState           string `json:"healthState"`
-		PropagatedHealthState string `json:"propagatedHealthState"`
-		Type                  string `json:"_type"`
-	} `json:"state"`
-	OutgoingRelations []int64           `json:"outgoingRelations"`
-	IncomingRelations []int64           `json:"incomingRelations"`
-	Synchronized      bool              `json:"synchronized"`
-	FailingChecks     []any             `json:"failingChecks"`
-	RetrievalSource   string            `json:"retrievalSource"`
-	Identifiers       []string          `json:"identifiers"`
-	Tags              []string          `json:"tags"`
-	Properties        map[string]string `json:"properties"`
-	InternalType      string            `json:"_type"`
+	ID                  int64             `json:"id"`
+	Name                string            `json:"name"`
+	Description         string            `json:"description"`
+	LastUpdateTimestamp int64             `json:"lastUpdateTimestamp"`
+	Type                int64             `json:"type"`
+	Layer               int               `json:"layer"`
+	Domain              int               `json:"domain"`
+	Environments        []int64           `json:"environments"`
+	State               ViewElementState  `json:"state"`
+	OutgoingRelations   []int64           `json:"outgoingRelations"`
+	IncomingRelations   []int64           `json:"incomingRelations"`
+	Synchronized        bool              `json:"synchronized"`
+	FailingChecks       []any             `json:"failingChecks"`
+	RetrievalSource     string            `json:"retrievalSource"`
+	Identifiers         []string          `json:"identifiers"`
+	Tags                []string          `json:"tags"`
+	Properties          map[string]string `json:"properties"`
+	InternalType        string            `json:"_type"`
+}
+
+type ViewElementState struct {
+	ID                    int64  `json:"id"`
+	LastUpdateTimestamp   int64  `json:"lastUpdateTimestamp"`
+	HealthState           string `json:"healthState"`
+	PropagatedHealthState string `json:"propagatedHealthState"`
+	Type                  string `json:"_type"`
+}
+
+type DependencyDirection string
+
+const (
+	DependencyOneWay DependencyDirection = "ONE_WAY" // source depends on target
+	DependencyBoth   DependencyDirection = "BOTH"
+	DependencyNone   DependencyDirection = "NONE"
+)
+
+// ViewRelation connects the Source and Target components by id.
+type ViewRelation struct {
+	ID                  int64               `json:"id"`
+	Name                string              `json:"name"`
+	LastUpdateTimestamp int64               `json:"lastUpdateTimestamp"`
+	Type                int64               `json:"type"`
+	Source              int64               `json:"source"`
+	Target              int64               `json:"target"`
+	DependencyDirection DependencyDirection `json:"dependencyDirection"`
+	State               ViewElementState    `json:"state"`
+	Synchronized        bool                `json:"synchronized"`
+	FailingChecks       []any               `json:"failingChecks"`
+	Identifiers         []string            `json:"identifiers"`
+	Tags                []string            `json:"tags"`
+	InternalType        string              `json:"_type"`
+}
+
+// ViewIndirectRelation connects two components of the result through components that are not part of it.
+type ViewIndirectRelation struct {
+	Source              int64               `json:"source"`
+	Target              int64               `json:"target"`
+	DependencyDirection DependencyDirection `json:"dependencyDirection"`
+	InternalType        string              `json:"_type"`
+}
+
+// ViewComponentGroup stands in for the listed components when grouping is enabled.
+type ViewComponentGroup struct {
+	ID           int64            `json:"id"`
+	Name         string           `json:"name"`
+	Type         int64            `json:"type"`
+	Layer        int              `json:"layer"`
+	Domain       int              `json:"domain"`
+	Components   []int64          `json:"components"`
+	State        ViewElementState `json:"state"`
+	InternalType string           `json:"_type"`
+}
+
+// ViewGroupRelation joins the relations between groups, or between a group and a component.
+type ViewGroupRelation struct {
+	ID                  int64               `json:"id"`
+	Source              int64               `json:"source"`
+	Target              int64               `json:"target"`
+	Type                int64               `json:"type"`
+	DependencyDirection DependencyDirection `json:"dependencyDirection"`
+	Relations           []int64             `json:"relations"`
+	InternalType        string              `json:"_type"`
 }
 
 type ViewSnapshotRequest struct {
@@ -250,17 +478,39 @@ type ViewSnapshotMetadata struct {
 	QueryTime             int64  `json:"queryTime,omitempty"`
 }
 
-func NewViewSnapshotRequest(query string) *ViewSnapshotRequest {
-	return &ViewSnapshotRequest{
+// SetQueryTime evaluates the view at t, or now for the zero time.
+func (m *ViewSnapshotMetadata) SetQueryTime(t time.Time) {
+	m.QueryTime = 0
+	if !t.IsZero() {
+		m.QueryTime = t.UnixMilli()
+	}
+}
+
+// Time returns the time the view is evaluated at, or the zero time for now.
+func (m *ViewSnapshotMetadata) Time() time.Time {
+	if m.QueryTime == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(m.QueryTime)
+}
+
+// NewViewSnapshotRequest creates a request for the components of query, without grouping,
+// relations to neighbours or root causes unless opts enable them.
+func NewViewSnapshotRequest(query string, opts ...SnapshotOption) *ViewSnapshotRequest {
+	req := &ViewSnapshotRequest{
 		Type: "ViewSnapshotRequest",
 		Metadata: ViewSnapshotMetadata{
 			Type:         "QueryMetadata",
 			MinGroupSize: 2,
-			ShowCause:    "NONE",
+			ShowCause:    string(ShowCauseNone),
 		},
 		Query:        query,
 		QueryVersion: "0.0.1",
 	}
+	for _, opt := range opts {
+		opt(req)
+	}
+	return req
 }
 
 type NodeType struct {
@@ -381,11 +631,16 @@ type Trace struct {
 	Spans   []Span `json:"spans"`
 }
 
+// SpanTime is a millisecond epoch timestamp with the remaining nanoseconds in OffsetNanos.
 type SpanTime struct {
 	Timestamp   int64 `json:"timestamp"`
 	OffsetNanos int   `json:"offsetNanos"`
 }
 
+func (t SpanTime) Time() time.Time {
+	return time.UnixMilli(t.Timestamp).Add(time.Duration(t.OffsetNanos))
+}
+
 type Span struct {
 	StartTime          SpanTime    `json:"startTime"`
 	EndTime            SpanTime    `json:"endTime"`
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricDecoding(t *testing.T) {
+	var res MetricQueryResponse
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"status": "success",
+		"warnings": ["PromQL warning: encountered a mix of histograms and floats"],
+		"infos": ["PromQL info: metric might not be a counter"],
+		"data": {"resultType": "matrix", "result": [
+			{"metric": {"pod": "a"}, "values": [[1, "NaN"], [2, "+Inf"], [3, "-Inf"], [4, "1.5"]]}
+		]}
+	}`), &res))
+	assert.Equal(t, []string{"PromQL warning: encountered a mix of histograms and floats"}, res.Warnings)
+	assert.Equal(t, []string{"PromQL info: metric might not be a counter"}, res.Infos)
+	points := res.Data.Result[0].Points
+	require.Len(t, points, 4)
+	assert.True(t, math.IsNaN(points[0].Value))
+	assert.True(t, math.IsInf(points[1].Value, 1))
+	assert.True(t, math.IsInf(points[2].Value, -1))
+	assert.Equal(t, MetricPoint{Timestamp: 4, Value: 1.5}, points[3])
+}
+
+func TestMetricDecodingScalarAndString(t *testing.T) {
+	var data MetricData
+	require.NoError(t, json.Unmarshal([]byte(`{"resultType": "scalar", "result": [1730470751.5, "42"]}`), &data))
+	assert.Equal(t, []MetricResult{{Labels: map[string]string{}, Points: []MetricPoint{{Timestamp: 1730470751, Value: 42}}}}, data.Result)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"resultType": "scalar", "result": []}`), &data))
+	assert.Empty(t, data.Result)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"resultType": "string", "result": [1, "7"]}`), &data))
+	assert.Equal(t, 7.0, data.Result[0].Points[0].Value)
+
+	var res MetricQueryResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"status": "success", "data": null}`), &res))
+	assert.Empty(t, res.Data.Result)
+}
+
+func TestMetricDecodingHistograms(t *testing.T) {
+	var data MetricData
+	require.NoError(t, json.Unmarshal([]byte(`{"resultType": "vector", "result": [
+		{"metric": {"__name__": "latency"}, "histogram": [10, {"count": "3", "sum": "1.5", "buckets": [[0, "0", "0.5", "2"], [3, "0.5", "1", "1"]]}]}
+	]}`), &data))
+	assert.Equal(t, []MetricPoint{{Timestamp: 10, Value: 1.5, Histogram: &Histogram{
+		Count: 3,
+		Sum:   1.5,
+		Buckets: []HistogramBucket{
+			{Boundaries: 0, Lower: 0, Upper: 0.5, Count: 2},
+			{Boundaries: 3, Lower: 0.5, Upper: 1, Count: 1},
+		},
+	}}}, data.Result[0].Points)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"resultType": "matrix", "result": [
+		{"metric": {}, "histograms": [[10, {"count": "1", "sum": "2"}], [20, {"count": "2", "sum": "4"}]]}
+	]}`), &data))
+	require.Len(t, data.Result[0].Points, 2)
+	assert.Equal(t, 2.0, data.Result[0].Points[1].Histogram.Count)
+}
+
+func TestMetricDecodingErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown type":    `{"resultType": "table", "result": []}`,
+		"not an object":   `"oops"`,
+		"short sample":    `{"resultType": "vector", "result": [{"metric": {}, "value": [1]}]}`,
+		"text value":      `{"resultType": "vector", "result": [{"metric": {}, "value": [1, "abc"]}]}`,
+		"text timestamp":  `{"resultType": "matrix", "result": [{"metric": {}, "values": [["x", "1"]]}]}`,
+		"string not num":  `{"resultType": "string", "result": [1, "hello"]}`,
+		"bad bucket":      `{"resultType": "vector", "result": [{"metric": {}, "histogram": [1, {"count": "1", "sum": "1", "buckets": [[0, "1"]]}]}]}`,
+		"series as array": `{"resultType": "matrix", "result": [[1, "2"]]}`,
+	}
+	for name, body := range tests {
+		var data MetricData
+		err := json.Unmarshal([]byte(body), &data)
+		assert.Error(t, err, name)
+	}
+	var data MetricData
+	err := json.Unmarshal([]byte(`{"resultType": "vector", "result": [{"metric": {}, "value": [1, "1"]}, {"metric": {}, "value": [1, "x"]}]}`), &data)
+	assert.ErrorContains(t, err, "metric data: series 1")
+}
+
+func FuzzMetricQueryResponse(f *testing.F) {
+	for _, file := range []string{"../../testdata/api/metrics/query/response.json", "../../testdata/api/metrics/query_range/response.json"} {
+		b, err := os.ReadFile(file)
+		require.NoError(f, err)
+		f.Add(b)
+	}
+	f.Add([]byte(`{"status": "error", "errorType": "bad_data", "error": "parse error"}`))
+	f.Add([]byte(`{"status": "success", "data": {"resultType": "scalar", "result": []}}`))
+	f.Add([]byte(`{"status": "success", "data": {"resultType": "scalar", "result": [1, "NaN"]}}`))
+	f.Add([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "histogram": [1, {"count": "1", "sum": "1", "buckets": [[0, "0", "1", "1"]]}]}]}}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var res MetricQueryResponse
+		if err := json.Unmarshal(b, &res); err != nil {
+			return
+		}
+		for _, r := range res.Data.Result {
+			assert.NotNil(t, r.Labels)
+		}
+	})
+}
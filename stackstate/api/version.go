@@ -0,0 +1,123 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+)
+
+// CurrentApiVersion is the first server version assumed to offer the current api. Older servers
+// are sent to the legacy endpoints, e.g. traces/spans instead of traces/query. The 6.0 cutoff is
+// an assumption that has not been checked against release notes; change it, or force a mode with
+// sts.WithApiMode, when a server does not match it.
+var CurrentApiVersion = Version{Major: 6}
+
+// versionRetryInterval is how long a failed version detection is remembered before it is tried again.
+const versionRetryInterval = 5 * time.Minute
+
+// Version is a server version, ordered by Major, Minor and Patch.
+type Version struct {
+	Major  int    `json:"major"`
+	Minor  int    `json:"minor"`
+	Patch  int    `json:"patch"`
+	Diff   string `json:"diff"`
+	Commit string `json:"commit"`
+	IsDev  bool   `json:"isDev"`
+}
+
+// ParseVersion parses major[.minor[.patch]], optionally prefixed with v and followed by
+// -diff or +build, e.g. 6.1.2 or v5.1.0-snapshot.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	core := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		v.Diff = strings.TrimLeft(core[i:], "-+")
+		core = core[:i]
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	var numbers [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		numbers[i] = n
+	}
+	v.Major, v.Minor, v.Patch = numbers[0], numbers[1], numbers[2]
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 when v is older than, the same as or newer than o.
+func (v Version) Compare(o Version) int {
+	return cmp.Or(cmp.Compare(v.Major, o.Major), cmp.Compare(v.Minor, o.Minor), cmp.Compare(v.Patch, o.Patch))
+}
+
+// AtLeast reports whether v is o or newer.
+func (v Version) AtLeast(o Version) bool {
+	return v.Compare(o) >= 0
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Diff != "" {
+		s += "-" + v.Diff
+	}
+	return s
+}
+
+// ServerInfo returns the server information, fetched once and cached by the client. Information
+// without a version is not cached, so it is fetched again on the next call.
+func (c *Client) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.serverInfo != nil {
+		return c.serverInfo, nil
+	}
+	info, err := c.StatusCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.Version.Compare(Version{}) != 0 {
+		c.serverInfo = info
+	}
+	return info, nil
+}
+
+// useLegacyApi reports whether version dependent endpoints use the legacy api. When the mode
+// is detected and the server version cannot be fetched or is unknown, the current api is used
+// and detection is tried again after versionRetryInterval.
+func (c *Client) useLegacyApi(ctx context.Context) bool {
+	switch c.apiMode {
+	case sts.ApiModeLegacy:
+		return true
+	case sts.ApiModeCurrent:
+		return false
+	}
+	c.mu.Lock()
+	failed := c.versionFailed
+	c.mu.Unlock()
+	if !failed.IsZero() && time.Since(failed) < versionRetryInterval {
+		return false
+	}
+	info, err := c.ServerInfo(ctx)
+	if err == nil && info.Version.Compare(Version{}) == 0 {
+		err = fmt.Errorf("server reported no version")
+	}
+	if err != nil {
+		slog.Debug("api version detection failed", "error", err)
+		c.mu.Lock()
+		c.versionFailed = time.Now()
+		c.mu.Unlock()
+		return false
+	}
+	return !info.Version.AtLeast(CurrentApiVersion)
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := map[string]Version{
+		"6":                 {Major: 6},
+		"6.1":               {Major: 6, Minor: 1},
+		"v6.1.2":            {Major: 6, Minor: 1, Patch: 2},
+		"5.1.0-snapshot":    {Major: 5, Minor: 1, Diff: "snapshot"},
+		"7.0.0+build.1234 ": {Major: 7, Diff: "build.1234"},
+	}
+	for s, expected := range tests {
+		v, err := ParseVersion(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, v, s)
+	}
+	for _, s := range []string{"", "six", "6.x", "1.2.3.4", "6.-1"} {
+		_, err := ParseVersion(s)
+		assert.Error(t, err, s)
+	}
+	assert.Equal(t, "5.1.0-snapshot", Version{Major: 5, Minor: 1, Diff: "snapshot"}.String())
+}
+
+func TestVersionCompare(t *testing.T) {
+	v := Version{Major: 6, Minor: 1, Patch: 2}
+	assert.Equal(t, 0, v.Compare(Version{Major: 6, Minor: 1, Patch: 2, Commit: "abc"}))
+	assert.Equal(t, 1, v.Compare(Version{Major: 6, Minor: 0, Patch: 9}))
+	assert.Equal(t, -1, v.Compare(Version{Major: 6, Minor: 2}))
+	assert.Equal(t, -1, v.Compare(Version{Major: 10}))
+	assert.True(t, v.AtLeast(CurrentApiVersion))
+	assert.False(t, Version{Major: 5, Minor: 9}.AtLeast(CurrentApiVersion))
+}
+
+// versionedServer reports the given major version and records which trace endpoint is used.
+// The version request fails for a negative major and reports no version for 0.
+func versionedServer(t *testing.T, major int, infoRequests *atomic.Int32, endpoint *atomic.Value) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/server/info":
+			infoRequests.Add(1)
+			if major < 0 {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if major == 0 {
+				_, _ = w.Write([]byte(`{"deploymentMode": "SaaS"}`))
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"version": {"major": %d, "minor": 1, "patch": 0}, "deploymentMode": "SaaS"}`, major)
+		case "/api/traces/query", "/api/traces/spans":
+			endpoint.Store(r.URL.Path)
+			_, _ = w.Write([]byte(`{"traces": [], "spans": [], "pageSize": 10, "page": 0, "matchesTotal": 0}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}
+}
+
+func TestApiModeDetection(t *testing.T) {
+	tests := []struct {
+		name     string
+		major    int // see versionedServer
+		mode     sts.ApiMode
+		legacy   bool
+		expected string
+		requests int32
+	}{
+		{name: "current server", major: 6, expected: "/api/traces/query", requests: 1},
+		{name: "legacy server", major: 5, mode: sts.ApiModeAuto, expected: "/api/traces/spans", requests: 1},
+		{name: "detection fails", major: -1, expected: "/api/traces/query", requests: 1},
+		{name: "unknown version", major: 0, expected: "/api/traces/query", requests: 1},
+		{name: "forced current", major: 5, mode: sts.ApiModeCurrent, expected: "/api/traces/query"},
+		{name: "forced legacy", major: 6, mode: sts.ApiModeLegacy, expected: "/api/traces/spans"},
+		{name: "legacy flag", major: 6, legacy: true, expected: "/api/traces/spans"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var infoRequests atomic.Int32
+			var endpoint atomic.Value
+			conf := getConfig(t)
+			conf.ApiMode = tt.mode
+			conf.LegacyApi = tt.legacy
+			server := getMockServer(conf, versionedServer(t, tt.major, &infoRequests, &endpoint))
+			defer server.Close()
+			client := NewClient(conf)
+
+			for range 2 {
+				_, err := client.QueryTracesCtx(context.Background(), &TraceQueryRequest{Start: time.Now().Add(-time.Hour), End: time.Now(), PageSize: 10})
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, endpoint.Load())
+			}
+			assert.Equal(t, tt.requests, infoRequests.Load())
+		})
+	}
+}
+
+func TestApiModeDetectionRetriesAfterFailure(t *testing.T) {
+	tests := map[string]int{"request fails": -1, "unknown version": 0}
+	for name, major := range tests {
+		t.Run(name, func(t *testing.T) {
+			var infoRequests atomic.Int32
+			var endpoint atomic.Value
+			conf := getConfig(t)
+			server := getMockServer(conf, versionedServer(t, major, &infoRequests, &endpoint))
+			defer server.Close()
+			client := NewClient(conf)
+
+			assert.False(t, client.useLegacyApi(context.Background()))
+			assert.False(t, client.useLegacyApi(context.Background()))
+			assert.Equal(t, int32(1), infoRequests.Load())
+			client.versionFailed = time.Now().Add(-versionRetryInterval)
+			assert.False(t, client.useLegacyApi(context.Background()))
+			assert.Equal(t, int32(2), infoRequests.Load())
+		})
+	}
+}
+
+func TestWithApiModeOverridesConfig(t *testing.T) {
+	var infoRequests atomic.Int32
+	var endpoint atomic.Value
+	conf := getConfig(t)
+	conf.LegacyApi = true
+	server := getMockServer(conf, versionedServer(t, 5, &infoRequests, &endpoint))
+	defer server.Close()
+	client := NewClient(conf, sts.WithApiMode(sts.ApiModeCurrent))
+
+	_, err := client.QueryTraces(&TraceQueryRequest{Start: time.Now().Add(-time.Hour), End: time.Now(), PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "/api/traces/query", endpoint.Load())
+
+	info, err := client.ServerInfo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Version{Major: 5, Minor: 1}, info.Version)
+}
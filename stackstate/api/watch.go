@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+const DefaultWatchInterval = 30 * time.Second
+
+type EventType string
+
+const (
+	ComponentAdded     EventType = "ComponentAdded"
+	ComponentRemoved   EventType = "ComponentRemoved"
+	ComponentChanged   EventType = "ComponentChanged" // anything but the health state changed
+	HealthStateChanged EventType = "HealthStateChanged"
+	WatchFailed        EventType = "WatchFailed" // a poll failed, the watch continues
+)
+
+// WatchEvent is a change seen by Watch. Change is set for ComponentChanged and HealthStateChanged
+// events, Err for WatchFailed events.
+type WatchEvent struct {
+	Type      EventType
+	Time      time.Time
+	Component ComponentRef
+	Change    *ComponentChange
+	Err       error
+}
+
+// WatchOptions controls how Watch polls.
+type WatchOptions struct {
+	Interval    time.Duration // time between polls, DefaultWatchInterval when 0
+	Jitter      float64       // fraction [0,1] of the interval that is randomised
+	Buffer      int           // size of the event channel
+	EmitInitial bool          // report the components of the first poll as added
+}
+
+// Watch runs the query with ViewSnapshot on every interval and reports the differences between
+// consecutive results as events. The channel is closed once ctx is done.
+func (c *Client) Watch(ctx context.Context, query string, opts WatchOptions) <-chan WatchEvent {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	events := make(chan WatchEvent, max(opts.Buffer, 0))
+	go func() {
+		defer close(events)
+		// Without a previous result the first poll only sets the baseline.
+		var previous *ViewSnapshotResponse
+		if opts.EmitInitial {
+			previous = &ViewSnapshotResponse{}
+		}
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			res, err := c.ViewSnapshotAt(ctx, query, time.Time{})
+			if err != nil {
+				if ctx.Err() != nil || !send(ctx, events, WatchEvent{Type: WatchFailed, Time: time.Now(), Err: err}) {
+					return
+				}
+			} else {
+				if previous != nil {
+					for _, e := range watchEvents(DiffSnapshots(previous, res), time.Now()) {
+						if !send(ctx, events, e) {
+							return
+						}
+					}
+				}
+				previous = res
+			}
+			timer.Reset(jittered(interval, opts.Jitter))
+		}
+	}()
+	return events
+}
+
+func send(ctx context.Context, events chan<- WatchEvent, e WatchEvent) bool {
+	select {
+	case events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func jittered(d time.Duration, jitter float64) time.Duration {
+	if jitter > 0 {
+		d -= time.Duration(float64(d) * min(jitter, 1) * rand.Float64())
+	}
+	return d
+}
+
+// watchEvents turns a diff into events. A component whose health and other fields changed
+// results in a HealthStateChanged and a ComponentChanged event.
+func watchEvents(d *TopologyDiff, now time.Time) []WatchEvent {
+	var events []WatchEvent
+	for _, c := range d.AddedComponents {
+		events = append(events, WatchEvent{Type: ComponentAdded, Time: now, Component: c})
+	}
+	for _, c := range d.RemovedComponents {
+		events = append(events, WatchEvent{Type: ComponentRemoved, Time: now, Component: c})
+	}
+	for _, c := range d.ChangedComponents {
+		if c.Health != nil {
+			events = append(events, WatchEvent{Type: HealthStateChanged, Time: now, Component: c.ComponentRef, Change: &c})
+		}
+		if len(c.Properties) > 0 || len(c.AddedLabels) > 0 || len(c.RemovedLabels) > 0 ||
+			len(c.AddedIdentifiers) > 0 || len(c.RemovedIdentifiers) > 0 {
+			events = append(events, WatchEvent{Type: ComponentChanged, Time: now, Component: c.ComponentRef, Change: &c})
+		}
+	}
+	return events
+}
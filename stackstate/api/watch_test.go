@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotSequence serves the given snapshots one per request and repeats the last one.
+// A nil snapshot answers with a server error.
+func snapshotSequence(t *testing.T, snapshots ...*ViewSnapshotResponse) http.HandlerFunc {
+	var n atomic.Int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		i := min(int(n.Add(1))-1, len(snapshots)-1)
+		if snapshots[i] == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(querySnapshotResult{ViewSnapshotResponse: *snapshots[i]}))
+	}
+}
+
+func collect(t *testing.T, events <-chan WatchEvent, n int) []WatchEvent {
+	var result []WatchEvent
+	timeout := time.After(5 * time.Second)
+	for len(result) < n {
+		select {
+		case e, ok := <-events:
+			require.True(t, ok, "events closed after %d events", len(result))
+			result = append(result, e)
+		case <-timeout:
+			require.Fail(t, "timed out", "got %d of %d events", len(result), n)
+		}
+	}
+	return result
+}
+
+func TestWatch(t *testing.T) {
+	first := loadSnapshot(t, "relations.json")
+	second := loadSnapshot(t, "relations.json")
+	second.Components[1].State.HealthState = "CLEAR"
+	second.Components[1].Properties = map[string]string{"version": "2"}
+	second.Components = second.Components[:2]
+	third := loadSnapshot(t, "relations.json")
+	third.Components = third.Components[:2]
+	third.Components[1].State.HealthState = "CLEAR"
+	third.Components[1].Properties = map[string]string{"version": "2"}
+	third.Components = append(third.Components, ViewComponent{ID: 9, Name: "cache"})
+
+	client, server := getClient(t, snapshotSequence(t, first, second, nil, third))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := client.Watch(ctx, "type = 'service'", WatchOptions{Interval: 5 * time.Millisecond, Jitter: 0.5})
+	got := collect(t, events, 5)
+	assert.Equal(t, ComponentRemoved, got[0].Type)
+	assert.Equal(t, ComponentRef{ID: 3, Name: "postgres"}, got[0].Component)
+	assert.Equal(t, HealthStateChanged, got[1].Type)
+	assert.Equal(t, &HealthTransition{Before: "DEVIATING", After: "CLEAR"}, got[1].Change.Health)
+	assert.Equal(t, ComponentChanged, got[2].Type)
+	assert.Equal(t, int64(2), got[2].Component.ID)
+	assert.Equal(t, []ValueChange{{Key: "version", After: "2"}}, got[2].Change.Properties)
+	assert.Equal(t, WatchFailed, got[3].Type)
+	var apiErr *APIError
+	require.ErrorAs(t, got[3].Err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	assert.Equal(t, ComponentAdded, got[4].Type)
+	assert.Equal(t, ComponentRef{ID: 9, Name: "cache"}, got[4].Component)
+	assert.False(t, got[4].Time.IsZero())
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestWatchEmitInitial(t *testing.T) {
+	client, server := getClient(t, snapshotSequence(t, loadSnapshot(t, "relations.json")))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := client.Watch(ctx, "type = 'service'", WatchOptions{Interval: time.Hour, EmitInitial: true, Buffer: 10})
+	got := collect(t, events, 3)
+	for i, e := range got {
+		assert.Equal(t, ComponentAdded, e.Type)
+		assert.Equal(t, int64(i+1), e.Component.ID)
+	}
+	cancel()
+	_, open := <-events
+	assert.False(t, open)
+}
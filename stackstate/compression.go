@@ -0,0 +1,122 @@
+package stackstate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Compression is the Content-Encoding of request bodies.
+type Compression string
+
+const (
+	CompressionNone    Compression = ""
+	CompressionGzip    Compression = "gzip"
+	CompressionDeflate Compression = "deflate" // zlib format, as HTTP defines it
+)
+
+// Compress encodes b. CompressionNone returns b unchanged.
+func (c Compression) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch c {
+	case CompressionNone:
+		return b, nil
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionDeflate:
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported compression '%s'", c)
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressTransport wraps base so that servers may answer with gzip or deflate encoded bodies,
+// which are decoded transparently. Requests that set their own Accept-Encoding are passed through
+// unchanged.
+func DecompressTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &decompressTransport{base: base}
+}
+
+type decompressTransport struct {
+	base http.RoundTripper
+}
+
+func (t *decompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") != "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	res, err := t.base.RoundTrip(req)
+	if err != nil || req.Method == http.MethodHead || res.Body == nil || res.Body == http.NoBody {
+		return res, err
+	}
+	encoding := Compression(strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))))
+	if encoding != CompressionGzip && encoding != CompressionDeflate {
+		return res, nil
+	}
+	res.Body = &decompressBody{body: res.Body, encoding: encoding}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return res, nil
+}
+
+// decompressBody decodes on first read, so empty bodies, e.g. of a 204, are no error.
+type decompressBody struct {
+	body     io.ReadCloser
+	encoding Compression
+	r        io.Reader
+	err      error
+}
+
+func (d *decompressBody) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		d.r, d.err = d.decoder()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decompressBody) decoder() (io.Reader, error) {
+	if d.encoding == CompressionGzip {
+		return gzip.NewReader(d.body)
+	}
+	// Some servers send raw deflate data without the zlib header.
+	br := bufio.NewReader(d.body)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func (d *decompressBody) Close() error {
+	if c, ok := d.r.(io.Closer); ok {
+		_ = c.Close()
+	}
+	return d.body.Close()
+}
@@ -0,0 +1,119 @@
+package stackstate
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"component"}`), 100)
+
+	b, err := CompressionNone.Compress(data)
+	require.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	b, err = CompressionGzip.Compress(data)
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+	assert.Less(t, len(b), len(data))
+
+	b, err = CompressionDeflate.Compress(data)
+	require.NoError(t, err)
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	_, err = Compression("br").Compress(data)
+	assert.Error(t, err)
+}
+
+func TestDecompressTransport(t *testing.T) {
+	const body = "hello compressed world"
+	encodings := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"raw": func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip, deflate", r.Header.Get("Accept-Encoding"))
+		encoding := r.URL.Query().Get("encoding")
+		if encoding == "" {
+			_, _ = w.Write([]byte(body))
+			return
+		}
+		if encoding == "raw" {
+			w.Header().Set("Content-Encoding", "deflate")
+		} else {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		if r.URL.Query().Has("empty") {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		zw := encodings[encoding](w)
+		_, _ = zw.Write([]byte(body))
+		_ = zw.Close()
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: DecompressTransport(nil)}
+
+	for _, query := range []string{"", "?encoding=gzip", "?encoding=deflate", "?encoding=raw"} {
+		res, err := client.Get(server.URL + query)
+		require.NoError(t, err, query)
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err, query)
+		_ = res.Body.Close()
+		assert.Equal(t, body, string(b), query)
+		assert.Empty(t, res.Header.Get("Content-Encoding"), query)
+	}
+
+	res, err := client.Get(server.URL + "?encoding=gzip&empty")
+	require.NoError(t, err)
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Empty(t, b)
+}
+
+func TestDecompressTransportKeepsCallerEncoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		_, _ = zw.Write([]byte("raw"))
+		_ = zw.Close()
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: DecompressTransport(nil)}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	r, err := gzip.NewReader(res.Body)
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "raw", string(b))
+}
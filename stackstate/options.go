@@ -0,0 +1,147 @@
+package stackstate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Options configures the HTTP behaviour shared by the api and receiver clients.
+// Certificate verification is enabled unless InsecureSkipVerify is explicitly set.
+type Options struct {
+	HTTPClient         *http.Client // When set, the TLS, proxy and timeout settings are ignored.
+	RootCAs            *x509.CertPool
+	Certificates       []tls.Certificate
+	Proxy              *url.URL
+	Timeout            time.Duration
+	InsecureSkipVerify bool
+	Retry              *RetryPolicy
+	ApiMode            ApiMode     // only used by the api client
+	MaxPayloadBytes    int         // only used by the receiver client, 0 for its default, negative for no limit
+	MaxPayloadElements int         // only used by the receiver client, 0 for no limit
+	Compression        Compression // request bodies, only used by the receiver client
+}
+
+type Option func(*Options)
+
+func NewOptions(opts ...Option) *Options {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHTTPClient supplies a fully configured http.Client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *Options) {
+		o.HTTPClient = client
+	}
+}
+
+// WithRootCAs verifies the server certificate against pool instead of the system roots.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(o *Options) {
+		o.RootCAs = pool
+	}
+}
+
+// WithClientCertificate presents cert to the server for mutual TLS.
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(o *Options) {
+		o.Certificates = append(o.Certificates, cert)
+	}
+}
+
+// WithProxy routes all requests through the proxy at proxyUrl.
+func WithProxy(proxyUrl *url.URL) Option {
+	return func(o *Options) {
+		o.Proxy = proxyUrl
+	}
+}
+
+// WithTimeout limits the total time of a request, including retries and reading the response.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = timeout
+	}
+}
+
+// WithInsecureSkipVerify disables server certificate verification.
+// Only use it against test servers with self-signed certificates.
+func WithInsecureSkipVerify() Option {
+	return func(o *Options) {
+		o.InsecureSkipVerify = true
+	}
+}
+
+// WithApiMode forces the api generation instead of detecting it from the server version.
+func WithApiMode(mode ApiMode) Option {
+	return func(o *Options) {
+		o.ApiMode = mode
+	}
+}
+
+// WithPayloadLimit splits receiver data into requests of at most maxBytes bytes and maxElements
+// components, relations, events or metric series. A maxBytes of 0 keeps the default of the receiver
+// client and a negative one disables the byte limit. A maxElements of 0 disables the element limit.
+func WithPayloadLimit(maxBytes, maxElements int) Option {
+	return func(o *Options) {
+		o.MaxPayloadBytes = maxBytes
+		o.MaxPayloadElements = maxElements
+	}
+}
+
+// WithCompression encodes receiver request bodies with gzip or deflate.
+func WithCompression(c Compression) Option {
+	return func(o *Options) {
+		o.Compression = c
+	}
+}
+
+// NewHTTPClient builds the http.Client described by the options.
+func (o *Options) NewHTTPClient() *http.Client {
+	client := o.HTTPClient
+	if client == nil {
+		client = o.newDefaultHTTPClient()
+	}
+	if o.Retry != nil {
+		c := *client
+		c.Transport = o.Retry.Transport(client.Transport)
+		client = &c
+	}
+	return client
+}
+
+func (o *Options) newDefaultHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		RootCAs:            o.RootCAs,
+		Certificates:       o.Certificates,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.Proxy != nil {
+		transport.Proxy = http.ProxyURL(o.Proxy)
+	}
+	return &http.Client{Transport: transport, Timeout: o.Timeout}
+}
+
+// LoadCertPool reads PEM encoded CA certificates from the given files into a new pool.
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		pem, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in '%s'", f)
+		}
+	}
+	return pool, nil
+}
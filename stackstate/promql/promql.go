@@ -0,0 +1,360 @@
+// Package promql builds PromQL queries for the StackState metrics api without string formatting.
+//
+//	q := promql.Sum(promql.Rate(promql.Metric("http_requests_total", promql.Eq("job", "api")).Range(5*time.Minute))).By("code")
+//	q.String() // sum by (code) (rate(http_requests_total{job="api"}[5m]))
+package promql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is any PromQL expression.
+type Expr interface {
+	String() string
+}
+
+type MatchOp string
+
+const (
+	MatchEqual     MatchOp = "="
+	MatchNotEqual  MatchOp = "!="
+	MatchRegexp    MatchOp = "=~"
+	MatchNotRegexp MatchOp = "!~"
+)
+
+var (
+	labelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+)
+
+// Matcher is a label matcher inside a selector.
+type Matcher struct {
+	Label string
+	Op    MatchOp
+	Value string
+}
+
+func Eq(label, value string) Matcher {
+	return Matcher{Label: label, Op: MatchEqual, Value: value}
+}
+
+func Neq(label, value string) Matcher {
+	return Matcher{Label: label, Op: MatchNotEqual, Value: value}
+}
+
+func Re(label, pattern string) Matcher {
+	return Matcher{Label: label, Op: MatchRegexp, Value: pattern}
+}
+
+func NotRe(label, pattern string) Matcher {
+	return Matcher{Label: label, Op: MatchNotRegexp, Value: pattern}
+}
+
+func (m Matcher) String() string {
+	return quoteLabel(m.Label) + string(m.Op) + strconv.Quote(m.Value)
+}
+
+// Selector selects series by metric name and label matchers, optionally over a range and with an offset.
+// Its methods return modified copies.
+type Selector struct {
+	Metric   string
+	Matchers []Matcher
+	Window   time.Duration
+	Shift    time.Duration
+}
+
+func Metric(name string, matchers ...Matcher) Selector {
+	return Selector{Metric: name, Matchers: matchers}
+}
+
+func (s Selector) Where(matchers ...Matcher) Selector {
+	s.Matchers = append(append([]Matcher{}, s.Matchers...), matchers...)
+	return s
+}
+
+// Range turns the selector into a range vector selector, e.g. [5m].
+func (s Selector) Range(window time.Duration) Selector {
+	s.Window = window
+	return s
+}
+
+// Offset shifts the evaluation time into the past.
+func (s Selector) Offset(offset time.Duration) Selector {
+	s.Shift = offset
+	return s
+}
+
+func (s Selector) String() string {
+	var b strings.Builder
+	matchers := s.Matchers
+	if s.Metric != "" && !metricName.MatchString(s.Metric) {
+		matchers = append([]Matcher{Eq("__name__", s.Metric)}, matchers...)
+	} else {
+		b.WriteString(s.Metric)
+	}
+	if len(matchers) > 0 || s.Metric == "" {
+		parts := make([]string, 0, len(matchers))
+		for _, m := range matchers {
+			parts = append(parts, m.String())
+		}
+		b.WriteString("{" + strings.Join(parts, ",") + "}")
+	}
+	if s.Window > 0 {
+		b.WriteString("[" + Duration(s.Window) + "]")
+	}
+	if s.Shift != 0 {
+		b.WriteString(" offset " + Duration(s.Shift))
+	}
+	return b.String()
+}
+
+// Number is a scalar literal.
+type Number float64
+
+func (n Number) String() string {
+	return strconv.FormatFloat(float64(n), 'g', -1, 64)
+}
+
+// String is a string literal, e.g. for label_replace arguments.
+type String string
+
+func (s String) String() string {
+	return strconv.Quote(string(s))
+}
+
+// Call is a function call.
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+// Func calls any PromQL function.
+func Func(name string, args ...Expr) Call {
+	return Call{Func: name, Args: args}
+}
+
+func Rate(v Expr) Call {
+	return Func("rate", v)
+}
+
+func IRate(v Expr) Call {
+	return Func("irate", v)
+}
+
+func Increase(v Expr) Call {
+	return Func("increase", v)
+}
+
+func (c Call) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, a := range c.Args {
+		args = append(args, a.String())
+	}
+	return c.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Aggregation aggregates a vector, optionally by or without labels.
+// Its methods return modified copies.
+type Aggregation struct {
+	Op      string
+	Param   Expr // e.g. k for topk, nil for most operators
+	Expr    Expr
+	Labels  []string
+	Without bool
+}
+
+func Aggregate(op string, expr Expr) Aggregation {
+	return Aggregation{Op: op, Expr: expr}
+}
+
+func Sum(expr Expr) Aggregation {
+	return Aggregate("sum", expr)
+}
+
+func Avg(expr Expr) Aggregation {
+	return Aggregate("avg", expr)
+}
+
+func Min(expr Expr) Aggregation {
+	return Aggregate("min", expr)
+}
+
+func Max(expr Expr) Aggregation {
+	return Aggregate("max", expr)
+}
+
+func Count(expr Expr) Aggregation {
+	return Aggregate("count", expr)
+}
+
+func TopK(k int, expr Expr) Aggregation {
+	return Aggregation{Op: "topk", Param: Number(k), Expr: expr}
+}
+
+func BottomK(k int, expr Expr) Aggregation {
+	return Aggregation{Op: "bottomk", Param: Number(k), Expr: expr}
+}
+
+func Quantile(q float64, expr Expr) Aggregation {
+	return Aggregation{Op: "quantile", Param: Number(q), Expr: expr}
+}
+
+func (a Aggregation) By(labels ...string) Aggregation {
+	a.Labels, a.Without = labels, false
+	return a
+}
+
+func (a Aggregation) WithoutLabels(labels ...string) Aggregation {
+	a.Labels, a.Without = labels, true
+	return a
+}
+
+func (a Aggregation) String() string {
+	var b strings.Builder
+	b.WriteString(a.Op)
+	if len(a.Labels) > 0 || a.Without {
+		if a.Without {
+			b.WriteString(" without ")
+		} else {
+			b.WriteString(" by ")
+		}
+		b.WriteString(labelList(a.Labels))
+	}
+	b.WriteString(" (")
+	if a.Param != nil {
+		b.WriteString(a.Param.String() + ", ")
+	}
+	b.WriteString(a.Expr.String() + ")")
+	return b.String()
+}
+
+// Binary is a binary operation. Both operands are parenthesised unless they are plain
+// selectors, numbers or calls, so operator precedence never depends on the operands.
+// Its methods return modified copies.
+type Binary struct {
+	Op         string
+	LHS, RHS   Expr
+	ReturnBool bool
+	Matching   string // "on" or "ignoring"
+	Labels     []string
+	Group      string // "group_left" or "group_right"
+	Include    []string
+}
+
+func BinaryOp(op string, lhs, rhs Expr) Binary {
+	return Binary{Op: op, LHS: lhs, RHS: rhs}
+}
+
+func Add(lhs, rhs Expr) Binary { return BinaryOp("+", lhs, rhs) }
+func Sub(lhs, rhs Expr) Binary { return BinaryOp("-", lhs, rhs) }
+func Mul(lhs, rhs Expr) Binary { return BinaryOp("*", lhs, rhs) }
+func Div(lhs, rhs Expr) Binary { return BinaryOp("/", lhs, rhs) }
+func Mod(lhs, rhs Expr) Binary { return BinaryOp("%", lhs, rhs) }
+func Pow(lhs, rhs Expr) Binary { return BinaryOp("^", lhs, rhs) }
+func Gt(lhs, rhs Expr) Binary  { return BinaryOp(">", lhs, rhs) }
+func Gte(lhs, rhs Expr) Binary { return BinaryOp(">=", lhs, rhs) }
+func Lt(lhs, rhs Expr) Binary  { return BinaryOp("<", lhs, rhs) }
+func Lte(lhs, rhs Expr) Binary { return BinaryOp("<=", lhs, rhs) }
+func And(lhs, rhs Expr) Binary { return BinaryOp("and", lhs, rhs) }
+func Or(lhs, rhs Expr) Binary  { return BinaryOp("or", lhs, rhs) }
+
+// Bool makes a comparison return 0 or 1 instead of filtering.
+func (b Binary) Bool() Binary {
+	b.ReturnBool = true
+	return b
+}
+
+func (b Binary) On(labels ...string) Binary {
+	b.Matching, b.Labels = "on", labels
+	return b
+}
+
+func (b Binary) Ignoring(labels ...string) Binary {
+	b.Matching, b.Labels = "ignoring", labels
+	return b
+}
+
+func (b Binary) GroupLeft(labels ...string) Binary {
+	b.Group, b.Include = "group_left", labels
+	return b
+}
+
+func (b Binary) GroupRight(labels ...string) Binary {
+	b.Group, b.Include = "group_right", labels
+	return b
+}
+
+func (b Binary) String() string {
+	var s strings.Builder
+	s.WriteString(operand(b.LHS) + " " + b.Op)
+	if b.ReturnBool {
+		s.WriteString(" bool")
+	}
+	if b.Matching != "" {
+		s.WriteString(" " + b.Matching + " " + labelList(b.Labels))
+	}
+	if b.Group != "" {
+		s.WriteString(" " + b.Group)
+		if len(b.Include) > 0 {
+			s.WriteString(" " + labelList(b.Include))
+		}
+	}
+	s.WriteString(" " + operand(b.RHS))
+	return s.String()
+}
+
+func operand(e Expr) string {
+	switch e.(type) {
+	case Selector, Number, Call, Aggregation:
+		return e.String()
+	}
+	return "(" + e.String() + ")"
+}
+
+func labelList(labels []string) string {
+	quoted := make([]string, 0, len(labels))
+	for _, l := range labels {
+		quoted = append(quoted, quoteLabel(l))
+	}
+	return "(" + strings.Join(quoted, ", ") + ")"
+}
+
+// quoteLabel quotes label names that are not valid identifiers, as supported since Prometheus 3.
+func quoteLabel(l string) string {
+	if labelName.MatchString(l) {
+		return l
+	}
+	return strconv.Quote(l)
+}
+
+// Duration formats d as a Prometheus duration, e.g. 1h30m or 1s500ms.
+// Precision below a millisecond is dropped.
+func Duration(d time.Duration) string {
+	if d < 0 {
+		return "-" + Duration(-d)
+	}
+	d = d.Truncate(time.Millisecond)
+	if d == 0 {
+		return "0s"
+	}
+	var b strings.Builder
+	for _, u := range []struct {
+		unit string
+		size time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+		{"ms", time.Millisecond},
+	} {
+		if n := d / u.size; n > 0 {
+			b.WriteString(strconv.FormatInt(int64(n), 10) + u.unit)
+			d -= n * u.size
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelector(t *testing.T) {
+	s := Metric("container_cpu_usage", Eq("namespace", "kube-system"), Re("pod_name", "coredns-.*"))
+	assert.Equal(t, `container_cpu_usage{namespace="kube-system",pod_name=~"coredns-.*"}`, s.String())
+	assert.Equal(t, `container_cpu_usage{namespace="kube-system",pod_name=~"coredns-.*"}[5m] offset 1h`, s.Range(5*time.Minute).Offset(time.Hour).String())
+	assert.Equal(t, `up`, Metric("up").String())
+	assert.Equal(t, `up{job!="a",instance!~"b"}`, Metric("up").Where(Neq("job", "a"), NotRe("instance", "b")).String())
+}
+
+func TestEscaping(t *testing.T) {
+	s := Metric("up", Eq("name", `it's a "quoted" \ value`+"\n"))
+	assert.Equal(t, `up{name="it's a \"quoted\" \\ value\n"}`, s.String())
+	assert.Equal(t, `{__name__="my.metric","k8s.pod"="x"}`, Metric("my.metric", Eq("k8s.pod", "x")).String())
+	assert.Equal(t, `sum by ("k8s.pod") (up)`, Sum(Metric("up")).By("k8s.pod").String())
+}
+
+func TestFunctionsAndAggregations(t *testing.T) {
+	requests := Metric("http_requests_total", Eq("job", "api")).Range(5 * time.Minute)
+	assert.Equal(t, `sum by (code) (rate(http_requests_total{job="api"}[5m]))`, Sum(Rate(requests)).By("code").String())
+	assert.Equal(t, `max without (instance) (irate(http_requests_total{job="api"}[5m]))`, Max(IRate(requests)).WithoutLabels("instance").String())
+	assert.Equal(t, `topk (3, increase(http_requests_total{job="api"}[5m]))`, TopK(3, Increase(requests)).String())
+	assert.Equal(t, `round(up, 0.001)`, Func("round", Metric("up"), Number(0.001)).String())
+	assert.Equal(t, `label_replace(up, "dst", "$1", "src", "(.*)")`,
+		Func("label_replace", Metric("up"), String("dst"), String("$1"), String("src"), String("(.*)")).String())
+}
+
+func TestBinary(t *testing.T) {
+	cpu := Sum(Metric("container_cpu_usage")).By("pod_name")
+	requests := Sum(Metric("kubernetes_cpu_requests")).By("pod_name")
+	q := Func("round", Div(Div(cpu, Number(1e9)), requests), Number(0.001))
+	assert.Equal(t, `round((sum by (pod_name) (container_cpu_usage) / 1e+09) / sum by (pod_name) (kubernetes_cpu_requests), 0.001)`, q.String())
+
+	assert.Equal(t, `a > bool 5`, Gt(Metric("a"), Number(5)).Bool().String())
+	assert.Equal(t, `a * on (pod) group_left (node) b`, Mul(Metric("a"), Metric("b")).On("pod").GroupLeft("node").String())
+	assert.Equal(t, `a / ignoring (code) b`, Div(Metric("a"), Metric("b")).Ignoring("code").String())
+}
+
+func TestDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                                  "0s",
+		10 * time.Second:                   "10s",
+		90 * time.Minute:                   "1h30m",
+		1500 * time.Millisecond:            "1s500ms",
+		50 * time.Hour:                     "2d2h",
+		-5 * time.Minute:                   "-5m",
+		time.Millisecond + time.Nanosecond: "1ms",
+	}
+	for d, expected := range tests {
+		assert.Equal(t, expected, Duration(d), d.String())
+	}
+}
@@ -0,0 +1,125 @@
+package receiver
+
+import (
+	"encoding/json"
+)
+
+// DefaultMaxPayloadBytes is the size a request body is kept under unless sts.WithPayloadLimit
+// sets another. Sizes are measured before compression.
+const DefaultMaxPayloadBytes = 4 << 20
+
+// chunker decides where a list of elements is split to keep requests within the limits.
+// Sizes are those of the JSON encoding, so a chunk only exceeds maxBytes when a single element does.
+type chunker struct {
+	maxBytes    int
+	maxElements int
+	overhead    int // size of a request without elements
+	size        int
+	count       int
+}
+
+func (c *Client) newChunker(overhead int) *chunker {
+	return &chunker{maxBytes: c.maxBytes, maxElements: c.maxElements, overhead: overhead, size: overhead}
+}
+
+// add accounts for an element of n bytes and reports whether it starts a new chunk.
+func (k *chunker) add(n int) bool {
+	n++ // separator
+	split := k.count > 0 &&
+		((k.maxBytes > 0 && k.size+n > k.maxBytes) || (k.maxElements > 0 && k.count >= k.maxElements))
+	if split {
+		k.size, k.count = k.overhead, 0
+	}
+	k.size += n
+	k.count++
+	return split
+}
+
+func jsonSize(v any) (int, error) {
+	b, err := json.Marshal(v)
+	return len(b), err
+}
+
+// topologyChunk is the part of a topology and its events sent in one request.
+type topologyChunk struct {
+	topology *Topology
+	events   []*Event
+}
+
+// splitTopology spreads the components, relations, deletions and events over chunks. Only the
+// first chunk starts the snapshot of t and only the last one stops it, so the receiver sees
+// the chunks as one snapshot. There is always at least one chunk.
+func (c *Client) splitTopology(source string, t *Topology, events []*Event) ([]topologyChunk, error) {
+	skeleton := NewEmptyStackStatePayload()
+	skeleton.InternalHostname = source
+	skeleton.Topologies = append(skeleton.Topologies, *c.newTopology(true, true))
+	skeleton.Events = Events{"events": {}}
+	overhead, err := jsonSize(skeleton)
+	if err != nil {
+		return nil, err
+	}
+	k := c.newChunker(overhead)
+	chunks := []topologyChunk{{topology: c.newTopology(false, false)}}
+	next := func(v any) (*topologyChunk, error) {
+		n, err := jsonSize(v)
+		if err != nil {
+			return nil, err
+		}
+		if k.add(n) {
+			chunks = append(chunks, topologyChunk{topology: c.newTopology(false, false)})
+		}
+		return &chunks[len(chunks)-1], nil
+	}
+	for _, comp := range t.Components {
+		chunk, err := next(comp)
+		if err != nil {
+			return nil, err
+		}
+		chunk.topology.Components = append(chunk.topology.Components, comp)
+	}
+	for _, rel := range t.Relations {
+		chunk, err := next(rel)
+		if err != nil {
+			return nil, err
+		}
+		chunk.topology.Relations = append(chunk.topology.Relations, rel)
+	}
+	for _, id := range t.DeleteIDs {
+		chunk, err := next(id)
+		if err != nil {
+			return nil, err
+		}
+		chunk.topology.DeleteIDs = append(chunk.topology.DeleteIDs, id)
+	}
+	for _, e := range events {
+		chunk, err := next(e)
+		if err != nil {
+			return nil, err
+		}
+		chunk.events = append(chunk.events, e)
+	}
+	chunks[0].topology.StartSnapshot = t.StartSnapshot
+	chunks[len(chunks)-1].topology.StopSnapshot = t.StopSnapshot
+	return chunks, nil
+}
+
+// splitSeries spreads metric series over chunks.
+func (c *Client) splitSeries(metrics []*Metric) ([][]*Metric, error) {
+	overhead, err := jsonSize(MetricSeries{Series: []*Metric{}})
+	if err != nil {
+		return nil, err
+	}
+	k := c.newChunker(overhead)
+	var chunks [][]*Metric
+	for _, m := range metrics {
+		n, err := jsonSize(m)
+		if err != nil {
+			return nil, err
+		}
+		if k.add(n) || len(chunks) == 0 {
+			chunks = append(chunks, nil)
+		}
+		chunks[len(chunks)-1] = append(chunks[len(chunks)-1], m)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,114 @@
+package receiver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func largeFactory(components, events, metrics int) *Factory {
+	f := NewFactory("test", "urn:test", "cluster")
+	for i := range components {
+		c := f.MustNewComponent(fmt.Sprintf("c%03d", i), fmt.Sprintf("component %d", i), "service")
+		c.AddProperty("description", strings.Repeat("x", 200))
+		if i > 0 {
+			f.MustNewRelation(fmt.Sprintf("c%03d", i-1), c.ID, "uses")
+		}
+	}
+	for i := range events {
+		f.AddEvent(f.NewEvent(fmt.Sprintf("event %d", i), "text", "Restart", "urn:test:c000"))
+	}
+	for i := range metrics {
+		f.AddMetric(f.NewMetric(fmt.Sprintf("metric_%d", i), float32(i)))
+	}
+	return f
+}
+
+// received counts the elements of all received payloads and checks that they form one snapshot.
+func received(t *testing.T, topologies []Topology, payloads []StackstatePayload) (components, relations, events int) {
+	for i, topology := range topologies {
+		assert.Equal(t, i == 0, topology.StartSnapshot, "start of chunk %d", i)
+		assert.Equal(t, i == len(topologies)-1, topology.StopSnapshot, "stop of chunk %d", i)
+		components += len(topology.Components)
+		relations += len(topology.Relations)
+	}
+	for _, pl := range payloads {
+		events += len(pl.Events["events"])
+	}
+	return components, relations, events
+}
+
+func TestSendSplitsByElements(t *testing.T) {
+	server := newIntakeServer(t)
+	client := server.client(sts.WithPayloadLimit(-1, 4))
+
+	require.NoError(t, client.Send(largeFactory(10, 3, 5)))
+	payloads := server.payloads
+	topologies := server.topologies()
+	require.Len(t, topologies, 6) // 10 components, 9 relations and 3 events
+	for i, topology := range topologies[:5] {
+		assert.Equal(t, 4, len(topology.Components)+len(topology.Relations)+len(payloads[i].Events["events"]))
+	}
+	components, relations, events := received(t, topologies, payloads)
+	assert.Equal(t, 10, components)
+	assert.Equal(t, 9, relations)
+	assert.Equal(t, 3, events)
+	assert.Equal(t, []int{4, 1}, server.series)
+}
+
+func TestSendSplitsByBytes(t *testing.T) {
+	server := newIntakeServer(t)
+	client := server.client(sts.WithPayloadLimit(4096, 0))
+
+	require.NoError(t, client.Send(largeFactory(50, 0, 0)))
+	for _, size := range server.sizes {
+		assert.LessOrEqual(t, size, 4096)
+	}
+	payloads := server.payloads
+	topologies := server.topologies()
+	assert.Greater(t, len(topologies), 5)
+	components, relations, _ := received(t, topologies, payloads)
+	assert.Equal(t, 50, components)
+	assert.Equal(t, 49, relations)
+}
+
+func TestSendOversizedElement(t *testing.T) {
+	server := newIntakeServer(t)
+	client := server.client(sts.WithPayloadLimit(1024, 0))
+
+	require.NoError(t, client.Send(largeFactory(3, 0, 0)))
+	// every component is too large to share a request with another one
+	topologies := server.topologies()
+	require.Len(t, topologies, 3)
+	for _, topology := range topologies[:2] {
+		assert.Len(t, topology.Components, 1)
+	}
+	assert.Len(t, topologies[2].Components, 1)
+	assert.Len(t, topologies[2].Relations, 2)
+}
+
+func TestSendDefaultLimit(t *testing.T) {
+	server := newIntakeServer(t)
+	require.NoError(t, server.client().Send(largeFactory(200, 10, 10)))
+	assert.Len(t, server.topologies(), 1)
+	assert.Equal(t, []int{10}, server.series)
+}
+
+func TestSendDeltaSplitsWithoutSnapshot(t *testing.T) {
+	server := newIntakeServer(t)
+	client := server.client(sts.WithPayloadLimit(-1, 5))
+	require.NoError(t, client.SendDelta(largeFactory(3, 0, 0)))
+	server.topologies()
+
+	require.NoError(t, client.SendDelta(largeFactory(10, 0, 0)))
+	topologies := server.topologies()
+	require.Len(t, topologies, 3) // 7 components and 7 relations added
+	for _, topology := range topologies {
+		assert.False(t, topology.StartSnapshot)
+		assert.False(t, topology.StopSnapshot)
+	}
+}
@@ -2,7 +2,7 @@ package receiver
 
 import (
 	"context"
-	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	rq "github.com/carlmjohnson/requests"
 	sts "github.com/ravan/stackstate-client/stackstate"
@@ -10,19 +10,24 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	url      string
-	conf     *sts.StackState
-	instance *Instance
+	url         string
+	conf        *sts.StackState
+	instance    *Instance
+	httpClient  *http.Client
+	maxBytes    int // request body limit, 0 for none
+	maxElements int // elements per request, 0 for none
+	compression sts.Compression
+	queue       *Queue
+	mu          sync.Mutex
+	sent        *sentTopology // what SendDelta last sent, nil until its first full snapshot
 }
 
 var (
-	transport = &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
 	DumpHttpRequest bool
 )
 
@@ -31,97 +36,175 @@ const (
 	MetricEndpoint string = "receiver/stsAgent/api/v1/series"
 )
 
-func NewClient(conf *sts.StackState, instance *Instance) *Client {
+func NewClient(conf *sts.StackState, instance *Instance, opts ...sts.Option) *Client {
 	url, _ := strings.CutSuffix(conf.ApiUrl, "/")
-	return &Client{url: url, conf: conf, instance: instance}
+	o := conf.ClientOptions(opts...)
+	maxBytes := o.MaxPayloadBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxPayloadBytes
+	}
+	return &Client{
+		url:         url,
+		conf:        conf,
+		instance:    instance,
+		httpClient:  o.NewHTTPClient(),
+		maxBytes:    max(maxBytes, 0),
+		maxElements: max(o.MaxPayloadElements, 0),
+		compression: o.Compression,
+	}
 }
 
+// Send replaces the topology of the instance with the components and relations of f and sends its
+// events and metrics. Data beyond the payload limit is split over several requests.
 func (c *Client) Send(f *Factory) error {
+	defer c.flushQueued()
 	if len(f.components) > 0 || len(f.events) > 0 {
-		err := c.sendTopoAndEvents(f)
+		t := c.newTopology(true, true)
+		t.Components = maps.Values(f.components)
+		t.Relations = maps.Values(f.relations)
+		err := c.sendTopoAndEvents(f, t)
 		if err != nil {
 			return err
 		}
+		// The next SendDelta cannot know what this replaced, so it starts with a full snapshot.
+		c.mu.Lock()
+		c.sent = nil
+		c.mu.Unlock()
 	}
+	return c.sendMetrics(f)
+}
 
-	if len(f.metrics) > 0 {
-		series := MetricSeries{Series: f.metrics}
-		if len(f.components) == 0 && len(f.events) == 0 {
-			slog.Info("sending", "metrics", len(f.metrics))
+// sendUpdate adds or updates the components and relations of f without a snapshot, so the
+// receiver keeps everything else, and sends its events and metrics. It returns the number of
+// components, relations, events and series that were sent before an error.
+func (c *Client) sendUpdate(f *Factory) (int, error) {
+	defer c.flushQueued()
+	topology := len(f.components) + len(f.relations) + len(f.events)
+	if topology > 0 {
+		t := c.newTopology(false, false)
+		t.Components = sortedComponents(f)
+		t.Relations = sortedRelations(f)
+		if err := c.sendTopoAndEvents(f, t); err != nil {
+			return 0, err
+		}
+	}
+	if err := c.sendMetrics(f); err != nil {
+		return topology, err
+	}
+	return topology + len(f.metrics), nil
+}
+
+func (c *Client) sendMetrics(f *Factory) error {
+	if len(f.metrics) == 0 {
+		return nil
+	}
+	if len(f.components) == 0 && len(f.events) == 0 {
+		slog.Info("sending", "metrics", len(f.metrics))
+	}
+	chunks, err := c.splitSeries(f.metrics)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err := c.sendMetric(&MetricSeries{Series: chunk}); err != nil {
+			return err
 		}
-		return c.sendMetric(&series)
 	}
 	return nil
 }
 
 func (c *Client) sendMetric(series *MetricSeries) error {
-	var e map[string]interface{}
-	err := c.metricRequest().
-		BodyJSON(series).
-		ErrorJSON(&e).
-		Fetch(context.Background())
+	return c.post(MetricEndpoint, series, false)
+}
+
+func (c *Client) newTopology(start, stop bool) *Topology {
+	t := NewEmptyTopology()
+	t.StartSnapshot = start
+	t.StopSnapshot = stop
+	t.Instance.Type = c.instance.Type
+	t.Instance.URL = c.instance.URL
+	return t
+}
 
+// sendTopoAndEvents sends t and the events of f. When they are split, a failed request leaves a
+// snapshot open on the receiver until the next one starts.
+func (c *Client) sendTopoAndEvents(f *Factory, t *Topology) error {
+	chunks, err := c.splitTopology(f.source, t, f.events)
 	if err != nil {
-		slog.Error("Failed to send metric data to receiver", slog.Any("error", err))
 		return err
 	}
+	slog.Info("sending", "components", len(t.Components), "relations", len(t.Relations),
+		"deletes", len(t.DeleteIDs), "events", len(f.events), "metrics", len(f.metrics), "requests", len(chunks))
+	for _, chunk := range chunks {
+		if err := c.sendPayload(f.source, chunk); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *Client) sendTopoAndEvents(f *Factory) error {
+func (c *Client) sendPayload(source string, chunk topologyChunk) error {
 	pl := NewEmptyStackStatePayload()
 	pl.CollectionTimestamp = time.Now().Unix()
-	pl.InternalHostname = f.source
-	t := NewEmptyTopology()
-	t.StartSnapshot = true
-	t.StopSnapshot = true
-
-	t.Components = maps.Values(f.components)
-	t.Relations = maps.Values(f.relations)
-	t.Instance.Type = c.instance.Type
-	t.Instance.URL = c.instance.URL
-
-	pl.Topologies = append(pl.Topologies, *t)
-	if len(f.events) > 0 {
-		pl.Events = map[string][]*Event{"events": f.events}
+	pl.InternalHostname = source
+	pl.Topologies = append(pl.Topologies, *chunk.topology)
+	if len(chunk.events) > 0 {
+		pl.Events = map[string][]*Event{"events": chunk.events}
 	} else {
 		pl.Events = make(map[string][]*Event, 0)
 	}
 
-	slog.Info("sending", "components", len(t.Components),
-		"relations", len(t.Relations), "events", len(f.events), "metrics", len(f.metrics))
-	var e map[string]interface{}
-	err := c.agentRequest().
-		BodyJSON(&pl).
-		ErrorJSON(&e).
-		Fetch(context.Background())
+	// Topology describes a state, so it may be sent twice. Events would be duplicated.
+	return c.post(Endpoint, &pl, len(chunk.events) == 0)
+}
 
+// post sends v as JSON to the endpoint. Only idempotent requests are retried after the receiver
+// may have processed them. With a queue it is only queued and only an error to queue it is
+// returned; the caller sends the queue once all its requests are queued.
+func (c *Client) post(endpoint string, v any, idempotent bool) error {
+	body, err := json.Marshal(v)
 	if err != nil {
-		slog.Error("Failed to send data to receiver", "error", err, "details", e)
 		return err
 	}
-	return nil
+	body, err = c.compression.Compress(body)
+	if err != nil {
+		return err
+	}
+	if c.queue == nil {
+		return c.postBody(endpoint, c.compression, idempotent, body)
+	}
+	return c.queue.push(endpoint, c.compression, idempotent, body)
 }
 
-func (c *Client) agentRequest() *rq.Builder {
-	uri := fmt.Sprintf("%s/%s", c.url, Endpoint)
-	return request(uri).
-		Param("api_key", c.conf.ApiKey)
-}
+func (c *Client) postBody(endpoint string, encoding sts.Compression, idempotent bool, body []byte) error {
+	b := c.request(fmt.Sprintf("%s/%s", c.url, endpoint)).
+		Param("api_key", c.conf.ApiKey).
+		BodyBytes(body)
+	if encoding != sts.CompressionNone {
+		b.Header("Content-Encoding", string(encoding))
+	}
+	ctx := context.Background()
+	if idempotent {
+		ctx = sts.Idempotent(ctx)
+	}
+	var e map[string]interface{}
+	err := b.
+		ErrorJSON(&e).
+		Fetch(ctx)
 
-func (c *Client) metricRequest() *rq.Builder {
-	uri := fmt.Sprintf("%s/%s", c.url, MetricEndpoint)
-	return request(uri).
-		Param("api_key", c.conf.ApiKey)
+	if err != nil {
+		slog.Error("Failed to send data to receiver", "endpoint", endpoint, "error", err, "details", e)
+		return err
+	}
+	return nil
 }
 
-func request(uri string) *rq.Builder {
+func (c *Client) request(uri string) *rq.Builder {
 	b := rq.URL(uri).
-		ContentType("application/json")
+		ContentType("application/json").
+		Client(c.httpClient)
 	if DumpHttpRequest {
-		b.Transport(rq.Record(nil, "http_dump"))
-	} else {
-		b.Transport(transport)
+		b.Transport(rq.Record(c.httpClient.Transport, "http_dump"))
 	}
 	return b
 }
@@ -0,0 +1,38 @@
+package receiver
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnlyTopologyIsRetried(t *testing.T) {
+	server := newIntakeServer(t)
+	server.setStatus(http.StatusServiceUnavailable)
+	attempts := map[string]int{}
+	policy := sts.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = time.Millisecond
+	policy.OnAttempt = func(a sts.Attempt) {
+		attempts[a.Request.URL.Path]++
+	}
+	client := server.client(sts.WithRetry(policy))
+
+	assert.Error(t, client.Send(namedFactory("a")))
+	assert.Equal(t, policy.MaxAttempts, attempts["/"+Endpoint])
+
+	clear(attempts)
+	f := namedFactory("a")
+	f.AddEvent(f.NewEvent("restart", "", ""))
+	assert.Error(t, client.Send(f))
+	assert.Equal(t, 1, attempts["/"+Endpoint])
+
+	clear(attempts)
+	f = NewFactory("test", "", "cluster")
+	f.AddMetric(f.NewMetric("cpu", 1))
+	assert.Error(t, client.Send(f))
+	assert.Equal(t, 1, attempts["/"+MetricEndpoint])
+}
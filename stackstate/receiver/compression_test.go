@@ -0,0 +1,71 @@
+package receiver
+
+import (
+	"encoding/json"
+	"testing"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendCompressed(t *testing.T) {
+	for _, compression := range []sts.Compression{sts.CompressionGzip, sts.CompressionDeflate} {
+		t.Run(string(compression), func(t *testing.T) {
+			server := newIntakeServer(t)
+			client := server.client(sts.WithCompression(compression))
+
+			require.NoError(t, client.Send(largeFactory(20, 2, 3)))
+			assert.Equal(t, []string{string(compression), string(compression)}, server.encodings)
+			assert.Equal(t, []int{3}, server.series)
+			topologies := server.topologies()
+			require.Len(t, topologies, 1)
+			assert.Len(t, topologies[0].Components, 20)
+		})
+	}
+}
+
+func TestCompressionFromConfig(t *testing.T) {
+	server := newIntakeServer(t)
+	conf := &sts.StackState{ApiUrl: server.URL, ApiKey: "key", Compression: sts.CompressionGzip}
+	client := NewClient(conf, &Instance{Type: "test", URL: "local"})
+
+	require.NoError(t, client.Send(largeFactory(1, 0, 0)))
+	assert.Equal(t, []string{"gzip"}, server.encodings)
+}
+
+// BenchmarkPayloadCompression encodes the payload of a factory with 10000 components and relations
+// and reports the size of the request body. The none case is the cost of the JSON encoding alone.
+func BenchmarkPayloadCompression(b *testing.B) {
+	f := largeFactory(10000, 100, 0)
+	t := NewEmptyTopology()
+	t.Components = sortedComponents(f)
+	t.Relations = sortedRelations(f)
+	pl := NewEmptyStackStatePayload()
+	pl.Topologies = append(pl.Topologies, *t)
+	pl.Events = Events{"events": f.events}
+
+	for _, compression := range []sts.Compression{sts.CompressionNone, sts.CompressionGzip, sts.CompressionDeflate} {
+		name := string(compression)
+		if name == "" {
+			name = "none"
+		}
+		b.Run(name, func(b *testing.B) {
+			var size, compressed int
+			for range b.N {
+				data, err := json.Marshal(pl)
+				if err != nil {
+					b.Fatal(err)
+				}
+				body, err := compression.Compress(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size, compressed = len(data), len(body)
+			}
+			b.SetBytes(int64(size))
+			b.ReportMetric(float64(compressed), "body-bytes")
+			b.ReportMetric(float64(size)/float64(compressed), "ratio")
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package receiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/exp/maps"
+	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+// sentTopology holds a fingerprint of every component and relation the receiver has, by external id.
+type sentTopology struct {
+	components map[string]uint64
+	relations  map[string]uint64
+}
+
+func newSentTopology() *sentTopology {
+	return &sentTopology{components: make(map[string]uint64), relations: make(map[string]uint64)}
+}
+
+func (s *sentTopology) add(f *Factory) error {
+	for _, c := range f.components {
+		fp, err := fingerprint(c)
+		if err != nil {
+			return fmt.Errorf("component '%s': %w", c.ExternalID, err)
+		}
+		s.components[c.ExternalID] = fp
+	}
+	for _, r := range f.relations {
+		fp, err := fingerprint(r)
+		if err != nil {
+			return fmt.Errorf("relation '%s': %w", r.ExternalID, err)
+		}
+		s.relations[r.ExternalID] = fp
+	}
+	return nil
+}
+
+// fingerprint hashes the JSON the receiver gets, which has its map keys sorted.
+func fingerprint(v any) (uint64, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+	return h.Sum64(), nil
+}
+
+// SendDelta sends only the components and relations of f that were added or changed since the
+// previous SendDelta, and deletes the ones that are gone. Events and metrics are sent as by Send.
+// The first SendDelta, and the first after Send or Reset, sends a full snapshot to remove what
+// earlier runs left behind. When a request fails nothing is remembered, so its changes are sent
+// again by the next SendDelta.
+func (c *Client) SendDelta(f *Factory) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.flushQueued()
+	next := newSentTopology()
+	if err := next.add(f); err != nil {
+		return err
+	}
+	var t *Topology
+	if c.sent == nil {
+		t = c.newTopology(true, true)
+		t.Components = sortedComponents(f)
+		t.Relations = sortedRelations(f)
+	} else {
+		t = c.newTopology(false, false)
+		for _, comp := range sortedComponents(f) {
+			if fp, ok := c.sent.components[comp.ExternalID]; !ok || fp != next.components[comp.ExternalID] {
+				t.Components = append(t.Components, comp)
+			}
+		}
+		for _, rel := range sortedRelations(f) {
+			if fp, ok := c.sent.relations[rel.ExternalID]; !ok || fp != next.relations[rel.ExternalID] {
+				t.Relations = append(t.Relations, rel)
+			}
+		}
+		// relations first, so none is left pointing at a deleted component
+		t.DeleteIDs = append(t.DeleteIDs, removed(c.sent.relations, next.relations)...)
+		t.DeleteIDs = append(t.DeleteIDs, removed(c.sent.components, next.components)...)
+	}
+	if t.StartSnapshot || len(t.Components) > 0 || len(t.Relations) > 0 || len(t.DeleteIDs) > 0 || len(f.events) > 0 {
+		if err := c.sendTopoAndEvents(f, t); err != nil {
+			return err
+		}
+	}
+	c.sent = next
+	return c.sendMetrics(f)
+}
+
+// Reset forgets what SendDelta sent, so the next SendDelta sends a full snapshot.
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = nil
+}
+
+func removed(before, after map[string]uint64) []string {
+	var ids []string
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func sortedComponents(f *Factory) []*Component {
+	components := maps.Values(f.components)
+	slices.SortFunc(components, func(a, b *Component) int { return strings.Compare(a.ExternalID, b.ExternalID) })
+	return components
+}
+
+func sortedRelations(f *Factory) []*Relation {
+	relations := maps.Values(f.relations)
+	slices.SortFunc(relations, func(a, b *Relation) int { return strings.Compare(a.ExternalID, b.ExternalID) })
+	return relations
+}
+
+// Snapshot sends a topology snapshot in batches over several Send calls. The receiver replaces
+// the topology of the instance with everything sent from the first batch until Close.
+// A Snapshot is not safe for concurrent use.
+type Snapshot struct {
+	c       *Client
+	source  string
+	started bool
+	closed  bool
+	sent    *sentTopology
+}
+
+// StartSnapshot opens a snapshot. Nothing is sent until the first batch or Close.
+func (c *Client) StartSnapshot() *Snapshot {
+	return &Snapshot{c: c, sent: newSentTopology()}
+}
+
+// Send sends the components, relations, events and metrics of f as the next batch.
+func (s *Snapshot) Send(f *Factory) error {
+	if s.closed {
+		return fmt.Errorf("snapshot is closed")
+	}
+	defer s.c.flushQueued()
+	t := s.c.newTopology(!s.started, false)
+	t.Components = sortedComponents(f)
+	t.Relations = sortedRelations(f)
+	if err := s.c.sendTopoAndEvents(f, t); err != nil {
+		return err
+	}
+	s.started = true
+	s.source = f.source
+	if err := s.sent.add(f); err != nil {
+		return err
+	}
+	return s.c.sendMetrics(f)
+}
+
+// Close ends the snapshot. Closing a snapshot without batches removes the topology of the instance.
+// After Close, SendDelta continues from what the snapshot sent. Calling SendDelta while a
+// snapshot is open mixes its changes into the snapshot.
+func (s *Snapshot) Close() error {
+	if s.closed {
+		return nil
+	}
+	defer s.c.flushQueued()
+	t := s.c.newTopology(!s.started, true)
+	if err := s.c.sendTopoAndEvents(&Factory{source: s.source}, t); err != nil {
+		return err
+	}
+	s.closed = true
+	s.c.mu.Lock()
+	s.c.sent = s.sent
+	s.c.mu.Unlock()
+	return nil
+}
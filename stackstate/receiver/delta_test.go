@@ -0,0 +1,221 @@
+package receiver
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// intakeServer records the payloads posted to the intake endpoint, their sizes, the number of
+// series posted to the metric endpoint and the content encodings. Requests fail while status is set.
+type intakeServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  int // including failed ones
+	payloads  []StackstatePayload
+	sizes     []int
+	series    []int
+	encodings []string
+	status    int
+}
+
+func newIntakeServer(t *testing.T) *intakeServer {
+	s := &intakeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if s.status != 0 {
+			w.WriteHeader(s.status)
+			return
+		}
+		var reader io.Reader = r.Body
+		encoding := r.Header.Get("Content-Encoding")
+		s.encodings = append(s.encodings, encoding)
+		var err error
+		switch encoding {
+		case "gzip":
+			reader, err = gzip.NewReader(r.Body)
+		case "deflate":
+			reader, err = zlib.NewReader(r.Body)
+		}
+		assert.NoError(t, err)
+		body, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		switch r.URL.Path {
+		case "/" + Endpoint:
+			var pl StackstatePayload
+			assert.NoError(t, json.Unmarshal(body, &pl))
+			s.payloads = append(s.payloads, pl)
+			s.sizes = append(s.sizes, len(body))
+		case "/" + MetricEndpoint:
+			var series struct{ Series []json.RawMessage }
+			assert.NoError(t, json.Unmarshal(body, &series))
+			s.series = append(s.series, len(series.Series))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *intakeServer) client(opts ...sts.Option) *Client {
+	return NewClient(&sts.StackState{ApiUrl: s.URL, ApiKey: "key"}, &Instance{Type: "test", URL: "local"}, opts...)
+}
+
+func (s *intakeServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// topologies returns the topologies received since the previous call.
+func (s *intakeServer) topologies() []Topology {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []Topology
+	for _, pl := range s.payloads {
+		result = append(result, pl.Topologies...)
+	}
+	s.payloads = nil
+	return result
+}
+
+func externalIDs(t Topology) (components, relations []string) {
+	for _, c := range t.Components {
+		components = append(components, c.ExternalID)
+	}
+	for _, r := range t.Relations {
+		relations = append(relations, r.ExternalID)
+	}
+	return components, relations
+}
+
+func testFactory() *Factory {
+	f := NewFactory("test", "urn:test", "cluster")
+	f.MustNewComponent("a", "a", "service")
+	f.MustNewComponent("b", "b", "service")
+	f.MustNewComponent("c", "c", "database")
+	f.MustNewRelation("a", "b", "uses")
+	f.MustNewRelation("b", "c", "uses")
+	return f
+}
+
+func TestSendDelta(t *testing.T) {
+	server := newIntakeServer(t)
+	client := server.client()
+
+	require.NoError(t, client.SendDelta(testFactory()))
+	topologies := server.topologies()
+	require.Len(t, topologies, 1)
+	assert.True(t, topologies[0].StartSnapshot)
+	assert.True(t, topologies[0].StopSnapshot)
+	components, relations := externalIDs(topologies[0])
+	assert.Equal(t, []string{"urn:test:a", "urn:test:b", "urn:test:c"}, components)
+	assert.Equal(t, []string{"a --> b", "b --> c"}, relations)
+
+	// unchanged, nothing to send
+	require.NoError(t, client.SendDelta(testFactory()))
+	assert.Empty(t, server.topologies())
+
+	f := testFactory()
+	f.MustGetComponent("b").AddLabel("changed")
+	delete(f.components, "c")
+	delete(f.relations, relId("b", "c"))
+	f.MustNewComponent("d", "d", "queue")
+	require.NoError(t, client.SendDelta(f))
+	topologies = server.topologies()
+	require.Len(t, topologies, 1)
+	assert.False(t, topologies[0].StartSnapshot)
+	assert.False(t, topologies[0].StopSnapshot)
+	components, relations = externalIDs(topologies[0])
+	assert.Equal(t, []string{"urn:test:b", "urn:test:d"}, components)
+	assert.Empty(t, relations)
+	assert.Equal(t, []string{"b --> c", "urn:test:c"}, topologies[0].DeleteIDs)
+}
+
+func TestSendDeltaRetriesFailedChanges(t *testing.T) {
+	server := newIntakeServer(t)
+	client := server.client()
+	require.NoError(t, client.SendDelta(testFactory()))
+	server.topologies()
+
+	f := testFactory()
+	f.MustGetComponent("a").AddProperty("version", "2")
+	server.setStatus(http.StatusBadRequest)
+	require.Error(t, client.SendDelta(f))
+	server.setStatus(0)
+	require.NoError(t, client.SendDelta(f))
+	topologies := server.topologies()
+	require.Len(t, topologies, 1)
+	components, _ := externalIDs(topologies[0])
+	assert.Equal(t, []string{"urn:test:a"}, components)
+}
+
+func TestSendDeltaAfterSendOrReset(t *testing.T) {
+	server := newIntakeServer(t)
+	client := server.client()
+	require.NoError(t, client.SendDelta(testFactory()))
+	require.NoError(t, client.Send(testFactory()))
+	require.NoError(t, client.SendDelta(testFactory()))
+	client.Reset()
+	require.NoError(t, client.SendDelta(testFactory()))
+
+	topologies := server.topologies()
+	require.Len(t, topologies, 4)
+	for _, topology := range topologies {
+		assert.True(t, topology.StartSnapshot)
+		assert.True(t, topology.StopSnapshot)
+		assert.Len(t, topology.Components, 3)
+	}
+}
+
+func TestSnapshotBatches(t *testing.T) {
+	server := newIntakeServer(t)
+	client := server.client()
+
+	snapshot := client.StartSnapshot()
+	first := NewFactory("test", "", "cluster")
+	first.MustNewComponent("a", "a", "service")
+	second := NewFactory("test", "", "cluster")
+	second.MustNewComponent("b", "b", "service")
+	require.NoError(t, snapshot.Send(first))
+	require.NoError(t, snapshot.Send(second))
+	require.NoError(t, snapshot.Close())
+	require.NoError(t, snapshot.Close())
+	assert.Error(t, snapshot.Send(first))
+
+	topologies := server.topologies()
+	require.Len(t, topologies, 3)
+	flags := func(t Topology) [2]bool { return [2]bool{t.StartSnapshot, t.StopSnapshot} }
+	assert.Equal(t, [2]bool{true, false}, flags(topologies[0]))
+	assert.Equal(t, [2]bool{false, false}, flags(topologies[1]))
+	assert.Equal(t, [2]bool{false, true}, flags(topologies[2]))
+	assert.Empty(t, topologies[2].Components)
+
+	// deltas continue from the union of the batches
+	onlyA := NewFactory("test", "", "cluster")
+	onlyA.MustNewComponent("a", "a", "service")
+	require.NoError(t, client.SendDelta(onlyA))
+	topologies = server.topologies()
+	require.Len(t, topologies, 1)
+	assert.Empty(t, topologies[0].Components)
+	assert.Equal(t, []string{"b"}, topologies[0].DeleteIDs)
+}
+
+func TestEmptySnapshot(t *testing.T) {
+	server := newIntakeServer(t)
+	require.NoError(t, server.client().StartSnapshot().Close())
+	topologies := server.topologies()
+	require.Len(t, topologies, 1)
+	assert.True(t, topologies[0].StartSnapshot)
+	assert.True(t, topologies[0].StopSnapshot)
+}
@@ -0,0 +1,320 @@
+package receiver
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rq "github.com/carlmjohnson/requests"
+	sts "github.com/ravan/stackstate-client/stackstate"
+)
+
+const (
+	DefaultQueueMaxBytes = 256 << 20
+	DefaultQueueMaxAge   = 24 * time.Hour
+	queueEntrySuffix     = ".entry"
+	queueTempSuffix      = ".tmp"
+)
+
+// QueueOptions limits a Queue. Entries beyond the limits are dropped, oldest first.
+type QueueOptions struct {
+	MaxBytes int64         // total size of the entries, DefaultQueueMaxBytes when 0
+	MaxAge   time.Duration // age after which an entry is no longer sent, DefaultQueueMaxAge when 0
+}
+
+// Queue is a durable send queue in a local directory. Every request body is written to its own
+// file before it is sent and removed once the receiver accepted it, so data survives an
+// unreachable receiver and process restarts. Entries are sent in the order they were queued.
+// A directory must only be used by one Queue at a time.
+type Queue struct {
+	dir     string
+	opts    QueueOptions
+	now     func() time.Time
+	mu      sync.Mutex // guards entries, size and next
+	entries []queueEntry
+	size    int64
+	next    uint64
+	flushMu sync.Mutex // one flush at a time keeps the order
+}
+
+type queueEntry struct {
+	seq     uint64
+	size    int64
+	created time.Time
+}
+
+// queueHeader is the first line of an entry file, followed by the request body.
+type queueHeader struct {
+	Endpoint   string          `json:"endpoint"`
+	Encoding   sts.Compression `json:"encoding,omitempty"`
+	Idempotent bool            `json:"idempotent,omitempty"`
+	Created    time.Time       `json:"created"`
+}
+
+// OpenQueue opens the queue in dir, creating the directory when needed, and picks up the entries
+// a previous process left behind.
+func OpenQueue(dir string, opts QueueOptions) (*Queue, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultQueueMaxBytes
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultQueueMaxAge
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir, opts: opts, now: time.Now}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, queueTempSuffix) {
+			// an entry that was not completely written
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, queueEntrySuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(name, queueEntrySuffix) {
+			continue
+		}
+		header, _, err := q.read(seq)
+		if err != nil {
+			slog.Warn("dropping unreadable queue entry", "file", name, "error", err)
+			_ = os.Remove(q.path(seq))
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.entries = append(q.entries, queueEntry{seq: seq, size: info.Size(), created: header.Created})
+		q.size += info.Size()
+		q.next = max(q.next, seq+1)
+	}
+	slices.SortFunc(q.entries, func(a, b queueEntry) int { return cmp.Compare(a.seq, b.seq) })
+	return q, nil
+}
+
+// Len returns the number of queued entries.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Size returns the total size of the queued entries in bytes.
+func (q *Queue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, queueEntrySuffix))
+}
+
+// push writes a request body to a new entry, dropping the oldest entries to stay within MaxBytes.
+func (q *Queue) push(endpoint string, encoding sts.Compression, idempotent bool, body []byte) error {
+	var buf bytes.Buffer
+	header := queueHeader{Endpoint: endpoint, Encoding: encoding, Idempotent: idempotent, Created: q.now()}
+	if err := json.NewEncoder(&buf).Encode(header); err != nil {
+		return err
+	}
+	buf.Write(body)
+	size := int64(buf.Len())
+	if size > q.opts.MaxBytes {
+		return fmt.Errorf("request of %d bytes exceeds the queue limit of %d bytes", size, q.opts.MaxBytes)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.size+size > q.opts.MaxBytes && len(q.entries) > 0 {
+		slog.Warn("queue full, dropping oldest entry", "created", q.entries[0].created)
+		q.removeFirst()
+	}
+	seq := q.next
+	if err := writeFileSync(q.path(seq), buf.Bytes()); err != nil {
+		return err
+	}
+	q.next++
+	q.entries = append(q.entries, queueEntry{seq: seq, size: size, created: header.Created})
+	q.size += size
+	return nil
+}
+
+// writeFileSync writes through a temporary file, so a crash never leaves a partial entry.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + queueTempSuffix
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+func (q *Queue) read(seq uint64) (queueHeader, []byte, error) {
+	var header queueHeader
+	data, err := os.ReadFile(q.path(seq))
+	if err != nil {
+		return header, nil, err
+	}
+	line, body, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return header, nil, fmt.Errorf("missing queue entry header")
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, nil, err
+	}
+	return header, body, nil
+}
+
+// removeFirst deletes the oldest entry. The caller holds q.mu.
+func (q *Queue) removeFirst() {
+	e := q.entries[0]
+	if err := os.Remove(q.path(e.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("failed to remove queue entry", "error", err)
+	}
+	q.entries = q.entries[1:]
+	q.size -= e.size
+}
+
+// first returns the oldest entry that is not expired, dropping the expired ones.
+func (q *Queue) first() (queueEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.entries) > 0 {
+		e := q.entries[0]
+		if q.now().Sub(e.created) <= q.opts.MaxAge {
+			return e, true
+		}
+		slog.Warn("dropping expired queue entry", "created", e.created)
+		q.removeFirst()
+	}
+	return queueEntry{}, false
+}
+
+// done removes the entry e, which is the oldest one while a flush runs.
+func (q *Queue) done(e queueEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) > 0 && q.entries[0].seq == e.seq {
+		q.removeFirst()
+	}
+}
+
+// flush sends the entries in order until the queue is empty or a request fails. Entries the
+// receiver rejects as invalid are dropped, as sending them again cannot succeed.
+func (q *Queue) flush(send func(header queueHeader, body []byte) error) error {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+	for {
+		e, ok := q.first()
+		if !ok {
+			return nil
+		}
+		header, body, err := q.read(e.seq)
+		if err == nil {
+			err = send(header, body)
+			if err != nil && !rejected(err) {
+				return err
+			}
+		}
+		if err != nil {
+			slog.Error("dropping queue entry", "created", e.created, "error", err)
+		}
+		q.done(e)
+	}
+}
+
+// rejected reports whether the receiver refused a request for its content.
+func rejected(err error) bool {
+	var res *rq.ResponseError
+	if !errors.As(err, &res) {
+		return false
+	}
+	switch res.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusForbidden:
+		return false
+	}
+	return res.StatusCode >= 400 && res.StatusCode < 500
+}
+
+// SetQueue makes the client write every request to q before sending it. Requests that cannot
+// be sent stay queued and are sent, in order, by the next Send, FlushQueue or ReplayQueue.
+// Send queues all its requests before it sends the queue once, and then only fails when the
+// data cannot be queued.
+func (c *Client) SetQueue(q *Queue) {
+	c.queue = q
+}
+
+// FlushQueue sends the queued requests now.
+func (c *Client) FlushQueue() error {
+	if c.queue == nil {
+		return nil
+	}
+	return c.queue.flush(func(header queueHeader, body []byte) error {
+		return c.postBody(header.Endpoint, header.Encoding, header.Idempotent, body)
+	})
+}
+
+// ReplayQueue sends the queued requests every interval until ctx is done, so they reach the
+// receiver once it is available again, also while there is nothing new to send.
+func (c *Client) ReplayQueue(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.flushQueued()
+		}
+	}
+}
+
+// flushQueued sends the queue at the end of a Send. When the receiver is unavailable the data
+// stays queued for the next call.
+func (c *Client) flushQueued() {
+	if c.queue == nil {
+		return
+	}
+	if err := c.FlushQueue(); err != nil {
+		slog.Warn("receiver unavailable, keeping data queued", "queued", c.queue.Len(), "error", err)
+	}
+}
@@ -0,0 +1,187 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queuedClient(t *testing.T, server *intakeServer, dir string, opts QueueOptions) (*Client, *Queue) {
+	q, err := OpenQueue(dir, opts)
+	require.NoError(t, err)
+	client := server.client(sts.WithCompression(sts.CompressionGzip))
+	client.SetQueue(q)
+	return client, q
+}
+
+func namedFactory(names ...string) *Factory {
+	f := NewFactory("test", "", "cluster")
+	for _, name := range names {
+		f.MustNewComponent(name, name, "service")
+	}
+	return f
+}
+
+func TestQueueKeepsDataWhileReceiverIsDown(t *testing.T) {
+	server := newIntakeServer(t)
+	client, q := queuedClient(t, server, t.TempDir(), QueueOptions{})
+
+	server.setStatus(http.StatusServiceUnavailable)
+	require.NoError(t, client.Send(namedFactory("a")))
+	require.NoError(t, client.Send(namedFactory("b")))
+	assert.Equal(t, 2, q.Len())
+	assert.Error(t, client.FlushQueue())
+	assert.Equal(t, 2, q.Len())
+
+	server.setStatus(0)
+	require.NoError(t, client.Send(namedFactory("c")))
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, int64(0), q.Size())
+	var sent []string
+	for _, topology := range server.topologies() {
+		components, _ := externalIDs(topology)
+		sent = append(sent, components...)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, sent)
+}
+
+func TestQueueFlushesOncePerSend(t *testing.T) {
+	server := newIntakeServer(t)
+	client, q := queuedClient(t, server, t.TempDir(), QueueOptions{})
+	client.maxElements = 10
+
+	server.setStatus(http.StatusServiceUnavailable)
+	require.NoError(t, client.Send(largeFactory(50, 5, 30)))
+	assert.Equal(t, 1, server.requests)
+	assert.Equal(t, 14, q.Len()) // 11 intake and 3 series requests
+
+	server.setStatus(0)
+	require.NoError(t, client.FlushQueue())
+	assert.Equal(t, 0, q.Len())
+	payloads := server.payloads
+	components, relations, events := received(t, server.topologies(), payloads)
+	assert.Equal(t, []int{50, 49, 5}, []int{components, relations, events})
+	assert.Equal(t, []int{10, 10, 10}, server.series)
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	server := newIntakeServer(t)
+	dir := t.TempDir()
+	client, _ := queuedClient(t, server, dir, QueueOptions{})
+	server.setStatus(http.StatusBadGateway)
+	for i := range 3 {
+		require.NoError(t, client.Send(namedFactory(fmt.Sprintf("c%d", i))))
+	}
+
+	// a write that was interrupted and a file that is not an entry
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000003.entry.tmp"), []byte("{"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("queue"), 0o600))
+
+	server.setStatus(0)
+	client, q := queuedClient(t, server, dir, QueueOptions{})
+	assert.Equal(t, 3, q.Len())
+	require.NoError(t, client.FlushQueue())
+	var sent []string
+	for _, topology := range server.topologies() {
+		components, _ := externalIDs(topology)
+		sent = append(sent, components...)
+	}
+	assert.Equal(t, []string{"c0", "c1", "c2"}, sent)
+	assert.NoFileExists(t, filepath.Join(dir, "00000000000000000003.entry.tmp"))
+	assert.FileExists(t, filepath.Join(dir, "README"))
+
+	// numbering continues after the entries found on disk
+	server.setStatus(http.StatusBadGateway)
+	require.NoError(t, client.Send(namedFactory("d")))
+	assert.FileExists(t, filepath.Join(dir, "00000000000000000003.entry"))
+}
+
+func TestQueueDropsOldestBeyondMaxBytes(t *testing.T) {
+	q, err := OpenQueue(t.TempDir(), QueueOptions{MaxBytes: 1000})
+	require.NoError(t, err)
+
+	body := make([]byte, 300)
+	for i := range 5 {
+		require.NoError(t, q.push(Endpoint, sts.CompressionNone, true, append([]byte(fmt.Sprint(i)), body...)))
+	}
+	assert.LessOrEqual(t, q.Size(), int64(1000))
+	assert.Equal(t, 2, q.Len())
+	assert.Error(t, q.push(Endpoint, sts.CompressionNone, true, make([]byte, 1000)))
+
+	var first []byte
+	require.NoError(t, q.flush(func(_ queueHeader, body []byte) error {
+		if first == nil {
+			first = body
+		}
+		return nil
+	}))
+	assert.Equal(t, byte('3'), first[0])
+}
+
+func TestQueueDropsExpiredEntries(t *testing.T) {
+	q, err := OpenQueue(t.TempDir(), QueueOptions{MaxAge: time.Hour})
+	require.NoError(t, err)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+	require.NoError(t, q.push(Endpoint, sts.CompressionNone, true, []byte("old")))
+	now = now.Add(45 * time.Minute)
+	require.NoError(t, q.push(Endpoint, sts.CompressionNone, true, []byte("new")))
+	now = now.Add(30 * time.Minute)
+
+	var sent []string
+	require.NoError(t, q.flush(func(_ queueHeader, body []byte) error {
+		sent = append(sent, string(body))
+		return nil
+	}))
+	assert.Equal(t, []string{"new"}, sent)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestQueueDropsRejectedEntries(t *testing.T) {
+	server := newIntakeServer(t)
+	client, q := queuedClient(t, server, t.TempDir(), QueueOptions{})
+	server.setStatus(http.StatusServiceUnavailable)
+	require.NoError(t, client.Send(namedFactory("a")))
+
+	server.setStatus(http.StatusBadRequest)
+	require.NoError(t, client.FlushQueue())
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestReplayQueue(t *testing.T) {
+	server := newIntakeServer(t)
+	client, q := queuedClient(t, server, t.TempDir(), QueueOptions{})
+	server.setStatus(http.StatusServiceUnavailable)
+	require.NoError(t, client.Send(namedFactory("a")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.ReplayQueue(ctx, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, q.Len())
+	server.setStatus(0)
+	assert.Eventually(t, func() bool { return q.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Len(t, server.topologies(), 1)
+}
+
+func TestIdleSenderFlushesQueue(t *testing.T) {
+	server := newIntakeServer(t)
+	client, q := queuedClient(t, server, t.TempDir(), QueueOptions{})
+	sender := NewSender(client, "test", SenderOptions{Interval: 5 * time.Millisecond})
+	defer sender.Close()
+
+	server.setStatus(http.StatusServiceUnavailable)
+	require.NoError(t, sender.AddComponent(newComponent(NewFactory("test", "", "cluster"), "a")))
+	assert.Eventually(t, func() bool { return sender.Stats().Sent == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, q.Len())
+	server.setStatus(0)
+	assert.Eventually(t, func() bool { return q.Len() == 0 }, time.Second, 5*time.Millisecond)
+}
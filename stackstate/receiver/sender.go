@@ -0,0 +1,249 @@
+package receiver
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultFlushInterval    = 10 * time.Second
+	DefaultSenderMaxItems   = 1000
+	DefaultSenderBufferSize = 10000
+)
+
+var (
+	ErrSenderClosed = errors.New("sender is closed")
+	ErrBufferFull   = errors.New("sender buffer is full")
+)
+
+// SenderOptions controls when a Sender flushes and what happens when its buffer is full.
+type SenderOptions struct {
+	Interval     time.Duration // time between flushes, DefaultFlushInterval when 0
+	MaxItems     int           // buffered items that trigger a flush, DefaultSenderMaxItems when 0, at most BufferSize
+	BufferSize   int           // buffered items before adding blocks, DefaultSenderBufferSize when 0
+	DropWhenFull bool          // drop items with ErrBufferFull instead of blocking when the buffer is full
+}
+
+// SenderStats counts items, i.e. components, relations, events and metric series.
+type SenderStats struct {
+	Sent    uint64 // accepted by the receiver, or queued when the client has a queue
+	Failed  uint64 // lost because a flush failed
+	Dropped uint64 // rejected because the buffer was full
+	Pending int    // buffered, waiting for the next flush
+	Flushes uint64
+}
+
+// Sender buffers topology, events and metrics added from any number of goroutines and sends
+// them in the background, every interval or once enough items are buffered.
+// Components and relations are added or updated without a snapshot, so the receiver keeps
+// those that are not sent again. A failed flush loses its items; give the client a Queue to
+// keep them. The queue is also flushed when nothing is buffered.
+type Sender struct {
+	client *Client
+	source string
+	opts   SenderOptions
+	slots  chan struct{} // one token per buffered item
+	kick   chan struct{}
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+
+	mu         sync.Mutex
+	isClosed   bool // no more items are buffered
+	components map[string]*Component
+	relations  map[string]*Relation
+	events     []*Event
+	metrics    []*Metric
+
+	flushMu                        sync.Mutex
+	sent, failed, dropped, flushes atomic.Uint64
+	closeErr                       error
+}
+
+// NewSender starts a sender that sends through client, with source as the sending host.
+// Close must be called to send the remaining items and stop it.
+func NewSender(client *Client, source string, opts SenderOptions) *Sender {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultFlushInterval
+	}
+	if opts.MaxItems <= 0 {
+		opts.MaxItems = DefaultSenderMaxItems
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultSenderBufferSize
+	}
+	// a buffer that fills up before MaxItems would wait for the interval
+	opts.MaxItems = min(opts.MaxItems, opts.BufferSize)
+	s := &Sender{
+		client:     client,
+		source:     source,
+		opts:       opts,
+		slots:      make(chan struct{}, opts.BufferSize),
+		kick:       make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+		done:       make(chan struct{}),
+		components: make(map[string]*Component),
+		relations:  make(map[string]*Relation),
+	}
+	go s.run()
+	return s
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		case <-s.kick:
+		}
+		_ = s.Flush()
+	}
+}
+
+// AddComponent buffers a component. A component with the same external id that is still
+// buffered is replaced.
+func (s *Sender) AddComponent(c *Component) error {
+	return s.add(func() bool {
+		_, replaced := s.components[c.ExternalID]
+		s.components[c.ExternalID] = c
+		return !replaced
+	})
+}
+
+// AddRelation buffers a relation. A relation with the same external id that is still buffered
+// is replaced.
+func (s *Sender) AddRelation(r *Relation) error {
+	return s.add(func() bool {
+		_, replaced := s.relations[r.ExternalID]
+		s.relations[r.ExternalID] = r
+		return !replaced
+	})
+}
+
+func (s *Sender) AddEvent(e *Event) error {
+	return s.add(func() bool {
+		s.events = append(s.events, e)
+		return true
+	})
+}
+
+func (s *Sender) AddMetric(m *Metric) error {
+	return s.add(func() bool {
+		s.metrics = append(s.metrics, m)
+		return true
+	})
+}
+
+// add takes a slot and buffers an item with put, which reports whether the item is new.
+func (s *Sender) add(put func() bool) error {
+	select {
+	case <-s.closed:
+		return ErrSenderClosed
+	default:
+	}
+	if s.opts.DropWhenFull {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			s.dropped.Add(1)
+			return ErrBufferFull
+		}
+	} else {
+		select {
+		case s.slots <- struct{}{}:
+		case <-s.closed:
+			return ErrSenderClosed
+		}
+	}
+	s.mu.Lock()
+	if s.isClosed {
+		s.mu.Unlock()
+		<-s.slots
+		return ErrSenderClosed
+	}
+	if !put() {
+		<-s.slots // replaced an item that already holds a slot
+	}
+	pending := s.pending()
+	s.mu.Unlock()
+	if pending >= s.opts.MaxItems {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// pending returns the number of buffered items. The caller holds s.mu.
+func (s *Sender) pending() int {
+	return len(s.components) + len(s.relations) + len(s.events) + len(s.metrics)
+}
+
+// Flush sends the buffered items now.
+func (s *Sender) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	f := &Factory{
+		source:     s.source,
+		components: s.components,
+		relations:  s.relations,
+		events:     s.events,
+		metrics:    s.metrics,
+	}
+	n := s.pending()
+	s.components = make(map[string]*Component)
+	s.relations = make(map[string]*Relation)
+	s.events = nil
+	s.metrics = nil
+	s.mu.Unlock()
+	if n == 0 {
+		// replay what the client queued while the receiver was unavailable
+		s.client.flushQueued()
+		return nil
+	}
+
+	sent, err := s.client.sendUpdate(f)
+	for range n {
+		<-s.slots
+	}
+	s.flushes.Add(1)
+	s.sent.Add(uint64(sent))
+	s.failed.Add(uint64(n - sent))
+	return err
+}
+
+// Close stops the sender and sends the remaining items. Adding items that wait for a slot
+// fail with ErrSenderClosed. Calling Close again returns the result of the first call.
+func (s *Sender) Close() error {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.isClosed = true
+		s.mu.Unlock()
+		close(s.closed)
+		<-s.done
+		s.closeErr = s.Flush()
+	})
+	return s.closeErr
+}
+
+// Stats returns the counts since the sender started.
+func (s *Sender) Stats() SenderStats {
+	s.mu.Lock()
+	pending := s.pending()
+	s.mu.Unlock()
+	return SenderStats{
+		Sent:    s.sent.Load(),
+		Failed:  s.failed.Load(),
+		Dropped: s.dropped.Load(),
+		Pending: pending,
+		Flushes: s.flushes.Load(),
+	}
+}
@@ -0,0 +1,182 @@
+package receiver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	sts "github.com/ravan/stackstate-client/stackstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSenderConcurrentAdds(t *testing.T) {
+	server := newIntakeServer(t)
+	sender := NewSender(server.client(), "test", SenderOptions{Interval: time.Hour, MaxItems: 50, BufferSize: 100})
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f := NewFactory("test", "", "cluster")
+			for i := range 100 {
+				assert.NoError(t, sender.AddComponent(newComponent(f, fmt.Sprintf("g%d-c%d", g, i))))
+				assert.NoError(t, sender.AddEvent(f.NewEvent("event", "text", "Restart")))
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, sender.Close())
+
+	stats := sender.Stats()
+	assert.Equal(t, uint64(1600), stats.Sent)
+	assert.Zero(t, stats.Pending)
+	assert.Greater(t, stats.Flushes, uint64(10))
+	components, events := 0, 0
+	server.mu.Lock()
+	for _, pl := range server.payloads {
+		events += len(pl.Events["events"])
+	}
+	server.mu.Unlock()
+	for _, topology := range server.topologies() {
+		assert.False(t, topology.StartSnapshot)
+		assert.False(t, topology.StopSnapshot)
+		components += len(topology.Components)
+	}
+	assert.Equal(t, 800, components)
+	assert.Equal(t, 800, events)
+}
+
+// newComponent creates a component without keeping it in the factory.
+func newComponent(f *Factory, id string) *Component {
+	c := f.MustNewComponent(id, id, "service")
+	delete(f.components, id)
+	return c
+}
+
+func TestSenderFlushesOnInterval(t *testing.T) {
+	server := newIntakeServer(t)
+	sender := NewSender(server.client(), "test", SenderOptions{Interval: 10 * time.Millisecond})
+	defer sender.Close()
+
+	f := NewFactory("test", "", "cluster")
+	require.NoError(t, sender.AddComponent(newComponent(f, "a")))
+	require.NoError(t, sender.AddMetric(f.NewMetric("cpu", 1)))
+	assert.Eventually(t, func() bool { return sender.Stats().Sent == 2 }, time.Second, 5*time.Millisecond)
+	assert.Len(t, server.topologies(), 1)
+	server.mu.Lock()
+	assert.Equal(t, []int{1}, server.series)
+	server.mu.Unlock()
+}
+
+func TestSenderFlushesOnMaxItems(t *testing.T) {
+	server := newIntakeServer(t)
+	sender := NewSender(server.client(), "test", SenderOptions{Interval: time.Hour, MaxItems: 3})
+	defer sender.Close()
+
+	f := NewFactory("test", "", "cluster")
+	for _, id := range []string{"a", "b"} {
+		require.NoError(t, sender.AddComponent(newComponent(f, id)))
+	}
+	// replacing a buffered component does not add an item
+	require.NoError(t, sender.AddComponent(newComponent(f, "a")))
+	assert.Equal(t, 2, sender.Stats().Pending)
+
+	r, err := f.NewRelation("a", "b", "uses")
+	require.NoError(t, err)
+	require.NoError(t, sender.AddRelation(r))
+	assert.Eventually(t, func() bool { return sender.Stats().Sent == 3 }, time.Second, 5*time.Millisecond)
+}
+
+func TestSenderDropsWhenFull(t *testing.T) {
+	server := newIntakeServer(t)
+	sender := NewSender(server.client(), "test", SenderOptions{Interval: time.Hour, MaxItems: 100, BufferSize: 2, DropWhenFull: true})
+	assert.Equal(t, 2, sender.opts.MaxItems)
+
+	// while the server holds the request of a flush, its items keep their slots
+	f := NewFactory("test", "", "cluster")
+	server.mu.Lock()
+	require.NoError(t, sender.AddComponent(newComponent(f, "a")))
+	require.NoError(t, sender.AddComponent(newComponent(f, "b")))
+	assert.Eventually(t, func() bool { return sender.Stats().Pending == 0 }, time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, sender.AddComponent(newComponent(f, "c")), ErrBufferFull)
+	server.mu.Unlock()
+	require.NoError(t, sender.Flush())
+	require.NoError(t, sender.AddComponent(newComponent(f, "c")))
+	require.NoError(t, sender.Close())
+
+	stats := sender.Stats()
+	assert.Equal(t, uint64(3), stats.Sent)
+	assert.Equal(t, uint64(1), stats.Dropped)
+}
+
+func TestSenderBlocksWhenFull(t *testing.T) {
+	server := newIntakeServer(t)
+	sender := NewSender(server.client(), "test", SenderOptions{Interval: time.Hour, BufferSize: 1})
+	f := NewFactory("test", "", "cluster")
+	server.mu.Lock()
+	require.NoError(t, sender.AddComponent(newComponent(f, "a")))
+
+	added := make(chan error)
+	go func() { added <- sender.AddComponent(newComponent(f, "b")) }()
+	select {
+	case <-added:
+		t.Fatal("add did not wait for a free slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	server.mu.Unlock()
+	require.NoError(t, <-added)
+	assert.Eventually(t, func() bool { return sender.Stats().Sent == 2 }, time.Second, 5*time.Millisecond)
+
+	server.mu.Lock()
+	require.NoError(t, sender.AddComponent(newComponent(f, "c")))
+	go func() { added <- sender.AddComponent(newComponent(f, "d")) }()
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan error)
+	go func() { closed <- sender.Close() }()
+	assert.ErrorIs(t, <-added, ErrSenderClosed)
+	server.mu.Unlock()
+	require.NoError(t, <-closed)
+	assert.ErrorIs(t, sender.AddEvent(f.NewEvent("late", "", "")), ErrSenderClosed)
+	assert.Equal(t, uint64(3), sender.Stats().Sent)
+}
+
+func TestSenderCountsFailedItems(t *testing.T) {
+	server := newIntakeServer(t)
+	server.setStatus(http.StatusBadRequest)
+	sender := NewSender(server.client(), "test", SenderOptions{Interval: time.Hour})
+
+	f := NewFactory("test", "", "cluster")
+	require.NoError(t, sender.AddComponent(newComponent(f, "a")))
+	assert.Error(t, sender.Close())
+	assert.Error(t, sender.Close())
+	stats := sender.Stats()
+	assert.Equal(t, uint64(1), stats.Failed)
+	assert.Zero(t, stats.Sent)
+}
+
+func TestSenderCountsFailedMetricsSeparately(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+MetricEndpoint {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	client := NewClient(&sts.StackState{ApiUrl: server.URL, ApiKey: "key"}, &Instance{Type: "test", URL: "local"})
+	sender := NewSender(client, "test", SenderOptions{Interval: time.Hour})
+
+	f := NewFactory("test", "", "cluster")
+	require.NoError(t, sender.AddComponent(newComponent(f, "a")))
+	require.NoError(t, sender.AddEvent(f.NewEvent("event", "text", "Restart")))
+	for _, name := range []string{"cpu", "memory", "disk"} {
+		require.NoError(t, sender.AddMetric(f.NewMetric(name, 1)))
+	}
+	assert.Error(t, sender.Close())
+	stats := sender.Stats()
+	assert.Equal(t, uint64(2), stats.Sent)
+	assert.Equal(t, uint64(3), stats.Failed)
+}
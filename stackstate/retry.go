@@ -0,0 +1,175 @@
+package stackstate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy retries requests that fail with a transient error using exponential backoff with jitter.
+// Requests that are not idempotent, e.g. a POST to /api/script, are only retried when the server
+// cannot have processed them: the connection could not be established or the server answered 429.
+type RetryPolicy struct {
+	MaxAttempts    int           // Total attempts including the first one. Values below 2 disable retries.
+	InitialBackoff time.Duration // Delay before the second attempt.
+	MaxBackoff     time.Duration // Upper bound for the computed delay. A Retry-After header may exceed it.
+	Multiplier     float64       // Growth factor applied to the delay after each attempt.
+	Jitter         float64       // Fraction [0,1] of the delay that is randomised.
+	RetryStatuses  []int         // Response codes considered transient.
+	OnAttempt      func(Attempt) // Optional hook called after every attempt.
+}
+
+// Attempt describes the outcome of a single try of a request.
+type Attempt struct {
+	Request    *http.Request
+	Number     int           // 1 based attempt number
+	StatusCode int           // 0 when no response was received
+	Err        error         // transport error, if any
+	Retry      bool          // whether another attempt follows
+	Delay      time.Duration // wait before the next attempt
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryStatuses:  []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	}
+}
+
+// WithRetry enables retries according to policy. Use DefaultRetryPolicy as a starting point.
+func WithRetry(policy *RetryPolicy) Option {
+	return func(o *Options) {
+		o.Retry = policy
+	}
+}
+
+type idempotentKey struct{}
+
+// Idempotent marks requests made with ctx as safe to repeat, regardless of the HTTP method.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
+
+// Transport wraps base so that requests are retried according to the policy.
+func (p *RetryPolicy) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{base: base, policy: p}
+}
+
+type retryTransport struct {
+	base   http.RoundTripper
+	policy *RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.policy
+	for n := 1; ; n++ {
+		if n > 1 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		res, err := t.base.RoundTrip(req)
+
+		a := Attempt{Request: req, Number: n, Err: err}
+		if res != nil {
+			a.StatusCode = res.StatusCode
+		}
+		a.Retry = n < p.MaxAttempts && p.retryable(req, res, err)
+		if a.Retry {
+			a.Delay = p.backoff(n, res)
+		}
+		if p.OnAttempt != nil {
+			p.OnAttempt(a)
+		}
+		if !a.Retry {
+			return res, err
+		}
+		if res != nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+
+		timer := time.NewTimer(a.Delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) retryable(req *http.Request, res *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		if req.Context().Err() != nil {
+			return false
+		}
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true
+		}
+		return isIdempotent(req)
+	}
+	if !slices.Contains(p.RetryStatuses, res.StatusCode) {
+		return false
+	}
+	return res.StatusCode == http.StatusTooManyRequests || isIdempotent(req)
+}
+
+func (p *RetryPolicy) backoff(attempt int, res *http.Response) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(max(p.Multiplier, 1), float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d -= d * min(p.Jitter, 1) * rand.Float64()
+	}
+	delay := time.Duration(d)
+	if res != nil {
+		delay = max(delay, retryAfter(res.Header.Get("Retry-After")))
+	}
+	return delay
+}
+
+// retryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package stackstate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRetryPolicy(attempts *[]Attempt) *RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.InitialBackoff = time.Millisecond
+	p.MaxBackoff = 5 * time.Millisecond
+	p.OnAttempt = func(a Attempt) {
+		*attempts = append(*attempts, a)
+	}
+	return p
+}
+
+func failingServer(failures int32, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	return server, &calls
+}
+
+func TestRetryTransientStatus(t *testing.T) {
+	server, calls := failingServer(2, http.StatusServiceUnavailable, nil)
+	defer server.Close()
+	var attempts []Attempt
+	client := NewOptions(WithRetry(testRetryPolicy(&attempts))).NewHTTPClient()
+
+	res, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int32(3), calls.Load())
+	require.Len(t, attempts, 3)
+	assert.True(t, attempts[0].Retry)
+	assert.Equal(t, http.StatusServiceUnavailable, attempts[1].StatusCode)
+	assert.False(t, attempts[2].Retry)
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	server, calls := failingServer(10, http.StatusBadGateway, nil)
+	defer server.Close()
+	var attempts []Attempt
+	client := NewOptions(WithRetry(testRetryPolicy(&attempts))).NewHTTPClient()
+
+	res, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestRetryPostOnlyWhenSafe(t *testing.T) {
+	server, calls := failingServer(2, http.StatusBadGateway, nil)
+	defer server.Close()
+	var attempts []Attempt
+	client := NewOptions(WithRetry(testRetryPolicy(&attempts))).NewHTTPClient()
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode, "a POST must not be retried after a 502")
+	assert.Equal(t, int32(1), calls.Load())
+
+	req, err := http.NewRequestWithContext(Idempotent(context.Background()), http.MethodPost, server.URL, strings.NewReader(`{}`))
+	require.NoError(t, err)
+	res, err = client.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode, "an idempotent POST is retried")
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	server, calls := failingServer(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	defer server.Close()
+	var attempts []Attempt
+	client := NewOptions(WithRetry(testRetryPolicy(&attempts))).NewHTTPClient()
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode, "a rate limited POST was not processed and can be retried")
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, time.Second, attempts[0].Delay)
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	server, _ := failingServer(10, http.StatusServiceUnavailable, http.Header{"Retry-After": {"60"}})
+	defer server.Close()
+	var attempts []Attempt
+	client := NewOptions(WithRetry(testRetryPolicy(&attempts))).NewHTTPClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
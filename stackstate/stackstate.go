@@ -1,8 +1,38 @@
 package stackstate
 
 type StackState struct {
-	ApiUrl    string `mapstructure:"api_url" validate:"required"`
-	ApiKey    string `mapstructure:"api_key" validate:"required"`
-	ApiToken  string `mapstructure:"api_token" validate:"required"`
-	LegacyApi bool   `mapstructure:"legacy_api"`
+	ApiUrl             string      `mapstructure:"api_url" validate:"required"`
+	ApiKey             string      `mapstructure:"api_key" validate:"required"`
+	ApiToken           string      `mapstructure:"api_token" validate:"required"`
+	LegacyApi          bool        `mapstructure:"legacy_api"` // same as ApiMode legacy
+	ApiMode            ApiMode     `mapstructure:"api_mode"`
+	InsecureSkipVerify bool        `mapstructure:"insecure_skip_verify"`
+	Compression        Compression `mapstructure:"compression"` // gzip or deflate for receiver requests
+}
+
+// ApiMode selects the generation of the StackState api used for version dependent endpoints.
+type ApiMode string
+
+const (
+	ApiModeAuto    ApiMode = "auto" // detect from the server version, also used when empty
+	ApiModeLegacy  ApiMode = "legacy"
+	ApiModeCurrent ApiMode = "current"
+)
+
+// ClientOptions applies opts on top of the settings carried by the configuration.
+func (s *StackState) ClientOptions(opts ...Option) *Options {
+	o := NewOptions(opts...)
+	if s.InsecureSkipVerify {
+		o.InsecureSkipVerify = true
+	}
+	if o.Compression == CompressionNone {
+		o.Compression = s.Compression
+	}
+	if o.ApiMode == "" {
+		o.ApiMode = s.ApiMode
+		if o.ApiMode == "" && s.LegacyApi {
+			o.ApiMode = ApiModeLegacy
+		}
+	}
+	return o
 }
@@ -0,0 +1,113 @@
+package trace
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ravan/stackstate-client/stackstate/api"
+)
+
+// Segment is a stretch of the critical path during which Node was the span doing the work.
+type Segment struct {
+	Node  *Node
+	Start time.Time
+	End   time.Time
+}
+
+func (s Segment) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// CriticalPath returns, in chronological order, the spans that determined the latency of the
+// longest top level span. At every point in time the path follows the child that finished last,
+// so the segment durations add up to the duration of that span.
+func (t *Tree) CriticalPath() []Segment {
+	top := t.Top()
+	if len(top) == 0 {
+		return nil
+	}
+	root := slices.MaxFunc(top, func(a, b *Node) int {
+		return cmp.Compare(a.Duration(), b.Duration())
+	})
+	var reversed []Segment
+	criticalPath(root, root.Start, root.End, &reversed)
+	slices.Reverse(reversed)
+	return reversed
+}
+
+// criticalPath appends the segments of n within [start,end] to path, latest first.
+func criticalPath(n *Node, start, end time.Time, path *[]Segment) {
+	children := slices.Clone(n.Children)
+	slices.SortStableFunc(children, func(a, b *Node) int {
+		return b.End.Compare(a.End)
+	})
+	cursor := end
+	for _, c := range children {
+		if !c.Start.Before(cursor) {
+			continue
+		}
+		childEnd := minTime(c.End, cursor)
+		if !childEnd.After(start) {
+			break
+		}
+		appendSegment(path, n, childEnd, cursor)
+		childStart := maxTime(c.Start, start)
+		criticalPath(c, childStart, childEnd, path)
+		cursor = childStart
+	}
+	appendSegment(path, n, start, cursor)
+}
+
+func appendSegment(path *[]Segment, n *Node, start, end time.Time) {
+	if !end.After(start) {
+		return
+	}
+	if l := len(*path); l > 0 && (*path)[l-1].Node == n && (*path)[l-1].Start.Equal(end) {
+		(*path)[l-1].Start = start
+		return
+	}
+	*path = append(*path, Segment{Node: n, Start: start, End: end})
+}
+
+// ServiceSummary aggregates the spans of a single service.
+type ServiceSummary struct {
+	Service     string
+	Spans       int
+	Errors      int
+	TotalTime   time.Duration // sum of the span durations
+	SelfTime    time.Duration // sum of the span self times
+	MaxDuration time.Duration
+}
+
+// Services summarises latency and errors per service, ordered by self time, largest first.
+func (t *Tree) Services() []ServiceSummary {
+	byService := make(map[string]*ServiceSummary)
+	for _, n := range t.Spans {
+		s, ok := byService[n.Span.ServiceName]
+		if !ok {
+			s = &ServiceSummary{Service: n.Span.ServiceName}
+			byService[n.Span.ServiceName] = s
+		}
+		s.Spans++
+		if n.IsError() {
+			s.Errors++
+		}
+		s.TotalTime += n.Duration()
+		s.SelfTime += n.SelfTime
+		s.MaxDuration = max(s.MaxDuration, n.Duration())
+	}
+	result := make([]ServiceSummary, 0, len(byService))
+	for _, s := range byService {
+		result = append(result, *s)
+	}
+	slices.SortFunc(result, func(a, b ServiceSummary) int {
+		return cmp.Or(cmp.Compare(b.SelfTime, a.SelfTime), strings.Compare(a.Service, b.Service))
+	})
+	return result
+}
+
+func isError(statusCode string) bool {
+	return strings.EqualFold(statusCode, string(api.StatusError))
+}
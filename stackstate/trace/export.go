@@ -0,0 +1,109 @@
+package trace
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ravan/stackstate-client/stackstate/api"
+)
+
+// Span kinds and status codes as the StackState api reports them in trace responses.
+const (
+	kindUnspecified = "Unspecified"
+	kindInternal    = "Internal"
+	kindServer      = "Server"
+	kindClient      = "Client"
+	kindProducer    = "Producer"
+	kindConsumer    = "Consumer"
+
+	statusUnset = "Unset"
+	statusOk    = "Ok"
+	statusError = "Error"
+)
+
+// Attribute keys used to carry span details in formats without a dedicated field.
+const (
+	tagSpanKind    = "span.kind"
+	tagStatusCode  = "otel.status_code"
+	tagScopeName   = "otel.scope.name"
+	tagError       = "error"
+	tagEvent       = "event"
+	tagServiceName = "service.name"
+	statusTagOk    = "OK"
+	statusTagError = "ERROR"
+	serviceUnknown = "unknown_service"
+)
+
+// normalizeKind maps both "SPAN_KIND_CLIENT" and "Client" style kinds to the response style.
+func normalizeKind(kind string) string {
+	switch strings.TrimPrefix(strings.ToUpper(kind), "SPAN_KIND_") {
+	case "INTERNAL":
+		return kindInternal
+	case "SERVER":
+		return kindServer
+	case "CLIENT":
+		return kindClient
+	case "PRODUCER":
+		return kindProducer
+	case "CONSUMER":
+		return kindConsumer
+	}
+	return kindUnspecified
+}
+
+func normalizeStatus(status string) string {
+	switch strings.ToLower(status) {
+	case string(api.StatusOk):
+		return statusOk
+	case string(api.StatusError):
+		return statusError
+	}
+	return statusUnset
+}
+
+func unixNanos(t api.SpanTime) int64 {
+	return t.Time().UnixNano()
+}
+
+func fromUnixNanos(nanos int64) api.SpanTime {
+	return api.SpanTime{Timestamp: nanos / int64(time.Millisecond), OffsetNanos: int(nanos % int64(time.Millisecond))}
+}
+
+func micros(t api.SpanTime) int64 {
+	return unixNanos(t) / int64(time.Microsecond)
+}
+
+func fromMicros(us int64) api.SpanTime {
+	return fromUnixNanos(us * int64(time.Microsecond))
+}
+
+// newSpan returns a span with the empty collections the api returns, so decoded spans compare
+// equal to spans read from a StackState response.
+func newSpan() api.Span {
+	return api.Span{
+		ResourceAttributes: api.Attributes{},
+		SpanAttributes:     api.Attributes{},
+		Events:             []api.SpanEvent{},
+		Links:              []any{},
+	}
+}
+
+// groupByTrace collects spans into traces, keeping the order in which trace ids first appear.
+func groupByTrace(spans []api.Span) []*api.Trace {
+	var traces []*api.Trace
+	byId := make(map[string]*api.Trace)
+	for _, s := range spans {
+		t, ok := byId[s.TraceID]
+		if !ok {
+			t = &api.Trace{TraceID: s.TraceID}
+			byId[s.TraceID] = t
+			traces = append(traces, t)
+		}
+		t.Spans = append(t.Spans, s)
+	}
+	return traces
+}
+
+func durationNanos(s *api.Span) int {
+	return int(unixNanos(s.EndTime) - unixNanos(s.StartTime))
+}
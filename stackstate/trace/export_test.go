@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"encoding/json"
+	"maps"
+	"testing"
+
+	"github.com/ravan/stackstate-client/stackstate/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip marshals v to JSON and decodes it into a new value of the same type.
+func roundTrip[T any](t *testing.T, v T) T {
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	var res T
+	require.NoError(t, json.Unmarshal(b, &res))
+	return res
+}
+
+// withoutParentType clears the span parent type, which none of the formats can carry.
+func withoutParentType(trace *api.Trace) []api.Span {
+	spans := make([]api.Span, len(trace.Spans))
+	for i, s := range trace.Spans {
+		s.SpanParentType = ""
+		spans[i] = s
+	}
+	return spans
+}
+
+func TestOTLPRoundTrip(t *testing.T) {
+	trace := loadTrace(t)
+	req := roundTrip(t, ToOTLP(trace))
+
+	require.Len(t, req.ResourceSpans, 1)
+	assert.Len(t, req.ResourceSpans[0].ScopeSpans, 2)
+	b, err := json.Marshal(req.ResourceSpans[0].ScopeSpans[0].Spans[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"startTimeUnixNano":"1730451052208477000"`)
+	assert.Contains(t, string(b), `"kind":3`)
+
+	traces := FromOTLP(req)
+	require.Len(t, traces, 1)
+	assert.Equal(t, trace.TraceID, traces[0].TraceID)
+	assert.ElementsMatch(t, withoutParentType(trace), traces[0].Spans)
+}
+
+func TestJaegerRoundTrip(t *testing.T) {
+	trace := loadTrace(t)
+	res := roundTrip(t, ToJaeger(trace))
+
+	require.Len(t, res.Data, 1)
+	assert.Len(t, res.Data[0].Processes, 1)
+	assert.Equal(t, int64(1730451052208477), res.Data[0].Spans[0].StartTime)
+	assert.Equal(t, int64(14061), res.Data[0].Spans[0].Duration)
+
+	traces := FromJaeger(res)
+	require.Len(t, traces, 1)
+	assert.Equal(t, withoutParentType(trace), traces[0].Spans)
+}
+
+func TestZipkinRoundTrip(t *testing.T) {
+	trace := loadTrace(t)
+	spans := roundTrip(t, ToZipkin(trace))
+
+	require.Len(t, spans, 21)
+	assert.Equal(t, "CLIENT", spans[0].Kind)
+	assert.Equal(t, "Rag101", spans[0].LocalEndpoint.ServiceName)
+
+	expected := withoutParentType(trace)
+	for i, s := range expected {
+		attrs := maps.Clone(s.ResourceAttributes)
+		maps.Copy(attrs, s.SpanAttributes)
+		s.SpanAttributes = attrs
+		s.ResourceAttributes = api.Attributes{}
+		for j := range s.Events {
+			s.Events[j].Attributes = api.Attributes{}
+		}
+		expected[i] = s
+	}
+	traces := FromZipkin(spans)
+	require.Len(t, traces, 1)
+	assert.Equal(t, expected, traces[0].Spans)
+}
+
+func TestExportStatusAndKind(t *testing.T) {
+	s := span("a", "", "svc", 0, 10, api.StatusError)
+	s.SpanKind = string(api.SpanKindServer)
+	trace := &api.Trace{TraceID: "t", Spans: []api.Span{s}}
+
+	otlp := ToOTLP(trace).ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, 2, otlp.Kind)
+	assert.Equal(t, 2, otlp.Status.Code)
+
+	zipkin := ToZipkin(trace)[0]
+	assert.Equal(t, "SERVER", zipkin.Kind)
+	assert.Contains(t, zipkin.Tags, "error")
+
+	for _, decoded := range [][]*api.Trace{FromOTLP(ToOTLP(trace)), FromJaeger(ToJaeger(trace)), FromZipkin(ToZipkin(trace))} {
+		assert.Equal(t, "Server", decoded[0].Spans[0].SpanKind)
+		assert.Equal(t, "Error", decoded[0].Spans[0].StatusCode)
+		assert.Equal(t, "svc", decoded[0].Spans[0].ServiceName)
+	}
+}
@@ -0,0 +1,196 @@
+package trace
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ravan/stackstate-client/stackstate/api"
+)
+
+// Jaeger UI JSON as served by the Jaeger query api and accepted by the UI's "JSON file" upload.
+
+type JaegerResponse struct {
+	Data []JaegerTrace `json:"data"`
+}
+
+type JaegerTrace struct {
+	TraceID   string                   `json:"traceID"`
+	Spans     []JaegerSpan             `json:"spans"`
+	Processes map[string]JaegerProcess `json:"processes"`
+}
+
+type JaegerSpan struct {
+	TraceID       string            `json:"traceID"`
+	SpanID        string            `json:"spanID"`
+	OperationName string            `json:"operationName"`
+	References    []JaegerReference `json:"references"`
+	StartTime     int64             `json:"startTime"` // microseconds since epoch
+	Duration      int64             `json:"duration"`  // microseconds
+	Tags          []JaegerTag       `json:"tags"`
+	Logs          []JaegerLog       `json:"logs"`
+	ProcessID     string            `json:"processID"`
+}
+
+type JaegerReference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type JaegerTag struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+type JaegerLog struct {
+	Timestamp int64       `json:"timestamp"` // microseconds since epoch
+	Fields    []JaegerTag `json:"fields"`
+}
+
+type JaegerProcess struct {
+	ServiceName string      `json:"serviceName"`
+	Tags        []JaegerTag `json:"tags"`
+}
+
+const (
+	jaegerChildOf    = "CHILD_OF"
+	jaegerTypeString = "string"
+	jaegerTypeBool   = "bool"
+)
+
+// ToJaeger converts traces into the Jaeger UI format. Jaeger timestamps have microsecond precision.
+// Span kind, status and scope are carried as the tags the OpenTelemetry Jaeger exporter uses.
+func ToJaeger(traces ...*api.Trace) *JaegerResponse {
+	res := &JaegerResponse{Data: make([]JaegerTrace, 0, len(traces))}
+	for _, t := range traces {
+		jt := JaegerTrace{TraceID: t.TraceID, Spans: make([]JaegerSpan, 0, len(t.Spans)), Processes: map[string]JaegerProcess{}}
+		processes := make(map[string]string)
+		for i := range t.Spans {
+			s := &t.Spans[i]
+			key := s.ServiceName + resourceKey(s.ResourceAttributes)
+			pid, ok := processes[key]
+			if !ok {
+				pid = fmt.Sprintf("p%d", len(processes)+1)
+				processes[key] = pid
+				jt.Processes[pid] = JaegerProcess{ServiceName: s.ServiceName, Tags: toJaegerTags(s.ResourceAttributes)}
+			}
+			jt.Spans = append(jt.Spans, toJaegerSpan(s, pid))
+		}
+		res.Data = append(res.Data, jt)
+	}
+	return res
+}
+
+func toJaegerSpan(s *api.Span, pid string) JaegerSpan {
+	span := JaegerSpan{
+		TraceID:       s.TraceID,
+		SpanID:        s.SpanID,
+		OperationName: s.SpanName,
+		References:    []JaegerReference{},
+		StartTime:     micros(s.StartTime),
+		Duration:      micros(s.EndTime) - micros(s.StartTime),
+		Tags:          toJaegerTags(s.SpanAttributes),
+		Logs:          make([]JaegerLog, 0, len(s.Events)),
+		ProcessID:     pid,
+	}
+	if s.ParentSpanID != "" {
+		span.References = append(span.References, JaegerReference{RefType: jaegerChildOf, TraceID: s.TraceID, SpanID: s.ParentSpanID})
+	}
+	if kind := normalizeKind(s.SpanKind); kind != kindUnspecified {
+		span.Tags = append(span.Tags, stringTag(tagSpanKind, strings.ToLower(kind)))
+	}
+	switch normalizeStatus(s.StatusCode) {
+	case statusOk:
+		span.Tags = append(span.Tags, stringTag(tagStatusCode, statusTagOk))
+	case statusError:
+		span.Tags = append(span.Tags, stringTag(tagStatusCode, statusTagError), JaegerTag{Key: tagError, Type: jaegerTypeBool, Value: true})
+	}
+	if s.ScopeName != "" {
+		span.Tags = append(span.Tags, stringTag(tagScopeName, s.ScopeName))
+	}
+	for _, e := range s.Events {
+		span.Logs = append(span.Logs, JaegerLog{
+			Timestamp: micros(e.Timestamp),
+			Fields:    append([]JaegerTag{stringTag(tagEvent, e.Name)}, toJaegerTags(e.Attributes)...),
+		})
+	}
+	return span
+}
+
+// FromJaeger converts Jaeger UI traces back into StackState traces.
+func FromJaeger(res *JaegerResponse) []*api.Trace {
+	traces := make([]*api.Trace, 0, len(res.Data))
+	for _, jt := range res.Data {
+		t := &api.Trace{TraceID: jt.TraceID, Spans: make([]api.Span, 0, len(jt.Spans))}
+		for _, js := range jt.Spans {
+			s := newSpan()
+			s.TraceID = js.TraceID
+			s.SpanID = js.SpanID
+			s.SpanName = js.OperationName
+			for _, ref := range js.References {
+				if ref.RefType == jaegerChildOf {
+					s.ParentSpanID = ref.SpanID
+					break
+				}
+			}
+			s.StartTime = fromMicros(js.StartTime)
+			s.EndTime = fromMicros(js.StartTime + js.Duration)
+			s.DurationNanos = durationNanos(&s)
+			p := jt.Processes[js.ProcessID]
+			s.ServiceName = p.ServiceName
+			maps.Copy(s.ResourceAttributes, fromJaegerTags(p.Tags))
+			s.SpanKind = kindUnspecified
+			s.StatusCode = statusUnset
+			for k, v := range fromJaegerTags(js.Tags) {
+				switch k {
+				case tagSpanKind:
+					s.SpanKind = normalizeKind(v)
+				case tagStatusCode:
+					s.StatusCode = normalizeStatus(v)
+				case tagScopeName:
+					s.ScopeName = v
+				case tagError:
+				default:
+					s.SpanAttributes[k] = v
+				}
+			}
+			for _, l := range js.Logs {
+				e := api.SpanEvent{Timestamp: fromMicros(l.Timestamp), Attributes: api.Attributes{}}
+				for k, v := range fromJaegerTags(l.Fields) {
+					if k == tagEvent {
+						e.Name = v
+					} else {
+						e.Attributes[k] = v
+					}
+				}
+				s.Events = append(s.Events, e)
+			}
+			t.Spans = append(t.Spans, s)
+		}
+		traces = append(traces, t)
+	}
+	return traces
+}
+
+func stringTag(key, value string) JaegerTag {
+	return JaegerTag{Key: key, Type: jaegerTypeString, Value: value}
+}
+
+func toJaegerTags(attrs api.Attributes) []JaegerTag {
+	tags := make([]JaegerTag, 0, len(attrs))
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		tags = append(tags, stringTag(k, attrs[k]))
+	}
+	return tags
+}
+
+func fromJaegerTags(tags []JaegerTag) api.Attributes {
+	attrs := make(api.Attributes, len(tags))
+	for _, t := range tags {
+		attrs[t.Key] = fmt.Sprint(t.Value)
+	}
+	return attrs
+}
@@ -0,0 +1,244 @@
+package trace
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/ravan/stackstate-client/stackstate/api"
+)
+
+// OTLP/JSON ExportTraceServiceRequest, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type OTLPExportRequest struct {
+	ResourceSpans []OTLPResourceSpans `json:"resourceSpans"`
+}
+
+type OTLPResourceSpans struct {
+	Resource   OTLPResource     `json:"resource"`
+	ScopeSpans []OTLPScopeSpans `json:"scopeSpans"`
+}
+
+type OTLPResource struct {
+	Attributes []OTLPKeyValue `json:"attributes"`
+}
+
+type OTLPScopeSpans struct {
+	Scope OTLPScope  `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+type OTLPScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type OTLPSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano OTLPNanos      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   OTLPNanos      `json:"endTimeUnixNano"`
+	Attributes        []OTLPKeyValue `json:"attributes"`
+	Events            []OTLPEvent    `json:"events"`
+	Status            OTLPStatus     `json:"status"`
+}
+
+type OTLPEvent struct {
+	TimeUnixNano OTLPNanos      `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []OTLPKeyValue `json:"attributes"`
+}
+
+type OTLPStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type OTLPKeyValue struct {
+	Key   string       `json:"key"`
+	Value OTLPAnyValue `json:"value"`
+}
+
+// OTLPAnyValue holds one of the OTLP value kinds. StackState reports every attribute as a string,
+// other kinds are only decoded.
+type OTLPAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func (v OTLPAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+// OTLPNanos is a unix nano timestamp, encoded as a string like every 64 bit integer in OTLP/JSON.
+type OTLPNanos int64
+
+func (n OTLPNanos) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(n), 10))
+}
+
+func (n *OTLPNanos) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		// plain numbers are accepted as well
+		var i int64
+		if err := json.Unmarshal(data, &i); err != nil {
+			return err
+		}
+		*n = OTLPNanos(i)
+		return nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	*n = OTLPNanos(i)
+	return err
+}
+
+var otlpKinds = []string{kindUnspecified, kindInternal, kindServer, kindClient, kindProducer, kindConsumer}
+var otlpStatuses = []string{statusUnset, statusOk, statusError}
+
+// ToOTLP converts traces into an OTLP export request. Spans are grouped by resource attributes
+// and scope name.
+func ToOTLP(traces ...*api.Trace) *OTLPExportRequest {
+	req := &OTLPExportRequest{ResourceSpans: []OTLPResourceSpans{}}
+	resources := make(map[string]int)
+	for _, t := range traces {
+		for i := range t.Spans {
+			s := &t.Spans[i]
+			resource := resourceAttributes(s)
+			key := resourceKey(resource)
+			ri, ok := resources[key]
+			if !ok {
+				ri = len(req.ResourceSpans)
+				resources[key] = ri
+				req.ResourceSpans = append(req.ResourceSpans, OTLPResourceSpans{
+					Resource:   OTLPResource{Attributes: toKeyValues(resource)},
+					ScopeSpans: []OTLPScopeSpans{},
+				})
+			}
+			rs := &req.ResourceSpans[ri]
+			si := slices.IndexFunc(rs.ScopeSpans, func(ss OTLPScopeSpans) bool { return ss.Scope.Name == s.ScopeName })
+			if si < 0 {
+				si = len(rs.ScopeSpans)
+				rs.ScopeSpans = append(rs.ScopeSpans, OTLPScopeSpans{Scope: OTLPScope{Name: s.ScopeName}, Spans: []OTLPSpan{}})
+			}
+			rs.ScopeSpans[si].Spans = append(rs.ScopeSpans[si].Spans, toOTLPSpan(s))
+		}
+	}
+	return req
+}
+
+func toOTLPSpan(s *api.Span) OTLPSpan {
+	span := OTLPSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentSpanID,
+		Name:              s.SpanName,
+		Kind:              slices.Index(otlpKinds, normalizeKind(s.SpanKind)),
+		StartTimeUnixNano: OTLPNanos(unixNanos(s.StartTime)),
+		EndTimeUnixNano:   OTLPNanos(unixNanos(s.EndTime)),
+		Attributes:        toKeyValues(s.SpanAttributes),
+		Events:            make([]OTLPEvent, 0, len(s.Events)),
+		Status:            OTLPStatus{Code: slices.Index(otlpStatuses, normalizeStatus(s.StatusCode))},
+	}
+	for _, e := range s.Events {
+		span.Events = append(span.Events, OTLPEvent{
+			TimeUnixNano: OTLPNanos(unixNanos(e.Timestamp)),
+			Name:         e.Name,
+			Attributes:   toKeyValues(e.Attributes),
+		})
+	}
+	return span
+}
+
+// FromOTLP converts an OTLP export request back into traces.
+func FromOTLP(req *OTLPExportRequest) []*api.Trace {
+	var spans []api.Span
+	for _, rs := range req.ResourceSpans {
+		resource := fromKeyValues(rs.Resource.Attributes)
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				s := newSpan()
+				s.TraceID = span.TraceID
+				s.SpanID = span.SpanID
+				s.ParentSpanID = span.ParentSpanID
+				s.SpanName = span.Name
+				s.ServiceName = resource[tagServiceName]
+				s.ScopeName = ss.Scope.Name
+				s.StartTime = fromUnixNanos(int64(span.StartTimeUnixNano))
+				s.EndTime = fromUnixNanos(int64(span.EndTimeUnixNano))
+				s.DurationNanos = durationNanos(&s)
+				s.SpanKind = otlpKinds[0]
+				if span.Kind > 0 && span.Kind < len(otlpKinds) {
+					s.SpanKind = otlpKinds[span.Kind]
+				}
+				s.StatusCode = otlpStatuses[0]
+				if span.Status.Code > 0 && span.Status.Code < len(otlpStatuses) {
+					s.StatusCode = otlpStatuses[span.Status.Code]
+				}
+				maps.Copy(s.ResourceAttributes, resource)
+				s.SpanAttributes = fromKeyValues(span.Attributes)
+				for _, e := range span.Events {
+					s.Events = append(s.Events, api.SpanEvent{
+						Timestamp:  fromUnixNanos(int64(e.TimeUnixNano)),
+						Name:       e.Name,
+						Attributes: fromKeyValues(e.Attributes),
+					})
+				}
+				spans = append(spans, s)
+			}
+		}
+	}
+	return groupByTrace(spans)
+}
+
+// resourceAttributes makes sure the service name is part of the resource, as OTLP has no other place for it.
+func resourceAttributes(s *api.Span) api.Attributes {
+	if _, ok := s.ResourceAttributes[tagServiceName]; ok || s.ServiceName == "" {
+		return s.ResourceAttributes
+	}
+	attrs := maps.Clone(s.ResourceAttributes)
+	if attrs == nil {
+		attrs = api.Attributes{}
+	}
+	attrs[tagServiceName] = s.ServiceName
+	return attrs
+}
+
+func resourceKey(attrs api.Attributes) string {
+	b, _ := json.Marshal(attrs) // map keys are sorted, so equal maps give equal keys
+	return string(b)
+}
+
+func toKeyValues(attrs api.Attributes) []OTLPKeyValue {
+	kvs := make([]OTLPKeyValue, 0, len(attrs))
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		v := attrs[k]
+		kvs = append(kvs, OTLPKeyValue{Key: k, Value: OTLPAnyValue{StringValue: &v}})
+	}
+	return kvs
+}
+
+func fromKeyValues(kvs []OTLPKeyValue) api.Attributes {
+	attrs := make(api.Attributes, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value.String()
+	}
+	return attrs
+}
@@ -0,0 +1,186 @@
+// Package trace analyses traces returned by the StackState api.
+package trace
+
+import (
+	"slices"
+	"time"
+
+	"github.com/ravan/stackstate-client/stackstate/api"
+)
+
+// Node is a span together with its position in the span tree.
+type Node struct {
+	Span     *api.Span
+	Parent   *Node
+	Children []*Node // ordered by start time
+	Start    time.Time
+	End      time.Time
+	SelfTime time.Duration // time not covered by any child span
+}
+
+// Duration is the total time of the span, including the time spent in children.
+func (n *Node) Duration() time.Duration {
+	return n.End.Sub(n.Start)
+}
+
+// IsError reports whether the span finished with an error status.
+func (n *Node) IsError() bool {
+	return isError(n.Span.StatusCode)
+}
+
+// Tree is the parent/child structure of the spans of a trace.
+type Tree struct {
+	TraceID string
+	Roots   []*Node // spans without a parent
+	Orphans []*Node // spans whose parent is not part of the trace, or that are their own ancestor
+	Spans   map[string]*Node
+}
+
+// NewTree links the spans of t by their ParentSpanID.
+func NewTree(t *api.Trace) *Tree {
+	tree := &Tree{
+		TraceID: t.TraceID,
+		Roots:   []*Node{},
+		Orphans: []*Node{},
+		Spans:   make(map[string]*Node, len(t.Spans)),
+	}
+	nodes := make([]*Node, 0, len(t.Spans))
+	for i := range t.Spans {
+		s := &t.Spans[i]
+		n := &Node{Span: s, Start: s.StartTime.Time()}
+		n.End = s.EndTime.Time()
+		if s.EndTime.Timestamp == 0 || n.End.Before(n.Start) {
+			n.End = n.Start.Add(time.Duration(s.DurationNanos))
+		}
+		tree.Spans[s.SpanID] = n
+		nodes = append(nodes, n)
+	}
+	parents := make(map[*Node]*Node, len(nodes))
+	for _, n := range nodes {
+		parentId := n.Span.ParentSpanID
+		switch parent, ok := tree.Spans[parentId]; {
+		case parentId == "":
+			tree.Roots = append(tree.Roots, n)
+		case !ok || parent == n:
+			tree.Orphans = append(tree.Orphans, n)
+		default:
+			parents[n] = parent
+		}
+	}
+	cycles := inCycle(nodes, parents)
+	for _, n := range nodes {
+		parent, ok := parents[n]
+		switch {
+		case !ok:
+		case cycles[n]:
+			tree.Orphans = append(tree.Orphans, n)
+		default:
+			n.Parent = parent
+			parent.Children = append(parent.Children, n)
+		}
+	}
+	for _, n := range nodes {
+		slices.SortStableFunc(n.Children, byStart)
+		n.SelfTime = selfTime(n)
+	}
+	slices.SortStableFunc(tree.Roots, byStart)
+	slices.SortStableFunc(tree.Orphans, byStart)
+	return tree
+}
+
+// inCycle returns the nodes whose parent chain leads back to themselves, e.g. a -> b -> a.
+func inCycle(nodes []*Node, parents map[*Node]*Node) map[*Node]bool {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*Node]int, len(nodes))
+	cycles := make(map[*Node]bool)
+	for _, n := range nodes {
+		var path []*Node
+		c := n
+		for c != nil && state[c] == 0 {
+			state[c] = visiting
+			path = append(path, c)
+			c = parents[c]
+		}
+		if c != nil && state[c] == visiting {
+			for _, p := range path[slices.Index(path, c):] {
+				cycles[p] = true
+			}
+		}
+		for _, p := range path {
+			state[p] = visited
+		}
+	}
+	return cycles
+}
+
+// Top returns the roots followed by the orphans, i.e. every node without a parent in the tree.
+func (t *Tree) Top() []*Node {
+	return append(slices.Clone(t.Roots), t.Orphans...)
+}
+
+// Walk visits the nodes depth first, starting with the roots and then the orphans.
+// Returning false from fn skips the children of the node.
+func (t *Tree) Walk(fn func(n *Node, depth int) bool) {
+	var walk func(n *Node, depth int)
+	walk = func(n *Node, depth int) {
+		if !fn(n, depth) {
+			return
+		}
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	for _, n := range t.Top() {
+		walk(n, 0)
+	}
+}
+
+// Duration is the time between the earliest start and the latest end of any span.
+func (t *Tree) Duration() time.Duration {
+	var start, end time.Time
+	for _, n := range t.Spans {
+		if start.IsZero() || n.Start.Before(start) {
+			start = n.Start
+		}
+		if n.End.After(end) {
+			end = n.End
+		}
+	}
+	return end.Sub(start)
+}
+
+// selfTime subtracts the union of the child intervals, clipped to the span, from the span duration.
+func selfTime(n *Node) time.Duration {
+	covered := time.Duration(0)
+	var cursor time.Time
+	for _, c := range n.Children {
+		start := maxTime(maxTime(c.Start, n.Start), cursor)
+		end := minTime(c.End, n.End)
+		if end.After(start) {
+			covered += end.Sub(start)
+			cursor = end
+		}
+	}
+	return max(n.Duration()-covered, 0)
+}
+
+func byStart(a, b *Node) int {
+	return a.Start.Compare(b.Start)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
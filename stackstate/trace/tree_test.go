@@ -0,0 +1,138 @@
+package trace
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ravan/stackstate-client/stackstate/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadTrace(t *testing.T) *api.Trace {
+	b, err := os.ReadFile("../../testdata/api/traces/response.json")
+	require.NoError(t, err)
+	var trace api.Trace
+	require.NoError(t, json.Unmarshal(b, &trace))
+	return &trace
+}
+
+// span creates a span starting at offset ms with the given duration in ms.
+func span(id, parent, service string, offset, duration int64, status api.StatusCode) api.Span {
+	start := api.SpanTime{Timestamp: 1_000_000 + offset}
+	return api.Span{
+		SpanID:        id,
+		ParentSpanID:  parent,
+		ServiceName:   service,
+		StartTime:     start,
+		EndTime:       api.SpanTime{Timestamp: start.Timestamp + duration},
+		DurationNanos: int(time.Duration(duration) * time.Millisecond),
+		StatusCode:    string(status),
+	}
+}
+
+func TestTreeFromFixture(t *testing.T) {
+	tree := NewTree(loadTrace(t))
+	require.Len(t, tree.Roots, 1)
+	assert.Empty(t, tree.Orphans)
+	assert.Len(t, tree.Spans, 21)
+
+	root := tree.Roots[0]
+	assert.Equal(t, "602147bcaaaf3d7b", root.Span.SpanID)
+	assert.Equal(t, time.Duration(root.Span.DurationNanos), root.Duration())
+
+	count := 0
+	tree.Walk(func(n *Node, depth int) bool {
+		count++
+		assert.LessOrEqual(t, n.SelfTime, n.Duration())
+		return true
+	})
+	assert.Equal(t, 21, count)
+
+	var total time.Duration
+	path := tree.CriticalPath()
+	for i, s := range path {
+		total += s.Duration()
+		if i > 0 {
+			assert.False(t, s.Start.Before(path[i-1].End), "segments must be chronological")
+		}
+	}
+	assert.Equal(t, root.Duration(), total)
+
+	services := tree.Services()
+	require.Len(t, services, 1)
+	assert.Equal(t, "Rag101", services[0].Service)
+	assert.Equal(t, 21, services[0].Spans)
+	assert.Equal(t, 0, services[0].Errors)
+}
+
+func TestTreeBreaksParentCycles(t *testing.T) {
+	tree := NewTree(&api.Trace{TraceID: "t", Spans: []api.Span{
+		span("root", "", "frontend", 0, 100, api.StatusOk),
+		span("a", "b", "backend", 10, 30, api.StatusOk),
+		span("b", "a", "backend", 20, 50, api.StatusOk),
+		span("c", "a", "db", 30, 20, api.StatusOk),
+		span("d", "root", "db", 40, 10, api.StatusOk),
+	}})
+
+	require.Len(t, tree.Roots, 1)
+	var orphans []string
+	for _, n := range tree.Orphans {
+		orphans = append(orphans, n.Span.SpanID)
+		assert.Nil(t, n.Parent)
+	}
+	assert.Equal(t, []string{"a", "b"}, orphans)
+	require.Len(t, tree.Spans["a"].Children, 1)
+	assert.Equal(t, "c", tree.Spans["a"].Children[0].Span.SpanID)
+	assert.Empty(t, tree.Spans["b"].Children)
+	assert.Equal(t, tree.Spans["root"], tree.Spans["d"].Parent)
+
+	visited := 0
+	tree.Walk(func(*Node, int) bool {
+		visited++
+		return true
+	})
+	assert.Equal(t, 5, visited)
+	assert.NotEmpty(t, tree.CriticalPath())
+}
+
+func TestTreeAnalysis(t *testing.T) {
+	tree := NewTree(&api.Trace{TraceID: "t", Spans: []api.Span{
+		span("root", "", "frontend", 0, 100, api.StatusOk),
+		span("a", "root", "backend", 10, 30, api.StatusOk),
+		span("b", "root", "backend", 20, 50, api.StatusError),
+		span("c", "b", "db", 30, 20, api.StatusOk),
+		span("lost", "missing", "db", 0, 5, api.StatusError),
+	}})
+
+	require.Len(t, tree.Roots, 1)
+	require.Len(t, tree.Orphans, 1)
+	assert.Equal(t, "lost", tree.Orphans[0].Span.SpanID)
+
+	// children a [10,40] and b [20,70] overlap, covering [10,70]
+	assert.Equal(t, 40*time.Millisecond, tree.Spans["root"].SelfTime)
+	assert.Equal(t, 30*time.Millisecond, tree.Spans["b"].SelfTime)
+	assert.Equal(t, 20*time.Millisecond, tree.Spans["c"].SelfTime)
+
+	var ids []string
+	for _, s := range tree.CriticalPath() {
+		ids = append(ids, s.Node.Span.SpanID)
+	}
+	assert.Equal(t, []string{"root", "a", "b", "c", "b", "root"}, ids)
+
+	services := tree.Services()
+	require.Len(t, services, 3)
+	assert.Equal(t, ServiceSummary{
+		Service:     "backend",
+		Spans:       2,
+		Errors:      1,
+		TotalTime:   80 * time.Millisecond,
+		SelfTime:    60 * time.Millisecond,
+		MaxDuration: 50 * time.Millisecond,
+	}, services[0])
+	assert.Equal(t, "frontend", services[1].Service)
+	assert.Equal(t, 2, services[2].Spans)
+	assert.Equal(t, 1, services[2].Errors)
+}
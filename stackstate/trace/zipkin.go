@@ -0,0 +1,123 @@
+package trace
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/ravan/stackstate-client/stackstate/api"
+)
+
+// Zipkin v2 JSON span, see https://zipkin.io/zipkin-api/#/default/post_spans
+
+type ZipkinSpan struct {
+	TraceID       string             `json:"traceId"`
+	ID            string             `json:"id"`
+	ParentID      string             `json:"parentId,omitempty"`
+	Name          string             `json:"name"`
+	Kind          string             `json:"kind,omitempty"`
+	Timestamp     int64              `json:"timestamp"` // microseconds since epoch
+	Duration      int64              `json:"duration"`  // microseconds
+	LocalEndpoint *ZipkinEndpoint    `json:"localEndpoint,omitempty"`
+	Annotations   []ZipkinAnnotation `json:"annotations,omitempty"`
+	Tags          map[string]string  `json:"tags,omitempty"`
+}
+
+type ZipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type ZipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"` // microseconds since epoch
+	Value     string `json:"value"`
+}
+
+// ToZipkin converts traces into Zipkin v2 spans. Zipkin has no resource, so resource attributes are
+// merged into the tags with span attributes taking precedence, and event attributes are dropped.
+// Internal spans have no kind in Zipkin.
+func ToZipkin(traces ...*api.Trace) []ZipkinSpan {
+	var spans []ZipkinSpan
+	for _, t := range traces {
+		for i := range t.Spans {
+			spans = append(spans, toZipkinSpan(&t.Spans[i]))
+		}
+	}
+	return spans
+}
+
+func toZipkinSpan(s *api.Span) ZipkinSpan {
+	service := s.ServiceName
+	if service == "" {
+		service = serviceUnknown
+	}
+	span := ZipkinSpan{
+		TraceID:       s.TraceID,
+		ID:            s.SpanID,
+		ParentID:      s.ParentSpanID,
+		Name:          s.SpanName,
+		Timestamp:     micros(s.StartTime),
+		Duration:      micros(s.EndTime) - micros(s.StartTime),
+		LocalEndpoint: &ZipkinEndpoint{ServiceName: service},
+		Tags:          make(map[string]string, len(s.ResourceAttributes)+len(s.SpanAttributes)),
+	}
+	switch kind := normalizeKind(s.SpanKind); kind {
+	case kindServer, kindClient, kindProducer, kindConsumer:
+		span.Kind = strings.ToUpper(kind)
+	}
+	maps.Copy(span.Tags, s.ResourceAttributes)
+	maps.Copy(span.Tags, s.SpanAttributes)
+	switch normalizeStatus(s.StatusCode) {
+	case statusOk:
+		span.Tags[tagStatusCode] = statusTagOk
+	case statusError:
+		span.Tags[tagStatusCode] = statusTagError
+		span.Tags[tagError] = ""
+	}
+	if s.ScopeName != "" {
+		span.Tags[tagScopeName] = s.ScopeName
+	}
+	for _, e := range s.Events {
+		span.Annotations = append(span.Annotations, ZipkinAnnotation{Timestamp: micros(e.Timestamp), Value: e.Name})
+	}
+	return span
+}
+
+// FromZipkin converts Zipkin v2 spans into traces. All tags other than the status and scope tags
+// become span attributes.
+func FromZipkin(spans []ZipkinSpan) []*api.Trace {
+	result := make([]api.Span, 0, len(spans))
+	for _, zs := range spans {
+		s := newSpan()
+		s.TraceID = zs.TraceID
+		s.SpanID = zs.ID
+		s.ParentSpanID = zs.ParentID
+		s.SpanName = zs.Name
+		s.StartTime = fromMicros(zs.Timestamp)
+		s.EndTime = fromMicros(zs.Timestamp + zs.Duration)
+		s.DurationNanos = durationNanos(&s)
+		s.SpanKind = kindInternal
+		if zs.Kind != "" {
+			s.SpanKind = normalizeKind(zs.Kind)
+		}
+		if zs.LocalEndpoint != nil {
+			s.ServiceName = zs.LocalEndpoint.ServiceName
+		}
+		s.StatusCode = statusUnset
+		for k, v := range zs.Tags {
+			switch k {
+			case tagStatusCode:
+				s.StatusCode = normalizeStatus(v)
+			case tagScopeName:
+				s.ScopeName = v
+			case tagError:
+				s.StatusCode = statusError
+			default:
+				s.SpanAttributes[k] = v
+			}
+		}
+		for _, a := range zs.Annotations {
+			s.Events = append(s.Events, api.SpanEvent{Timestamp: fromMicros(a.Timestamp), Name: a.Value, Attributes: api.Attributes{}})
+		}
+		result = append(result, s)
+	}
+	return groupByTrace(result)
+}